@@ -0,0 +1,370 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BatchImageResult struct {
+	Index    int         `json:"index"`
+	Filename string      `json:"filename"`
+	Result   OCRResponse `json:"result"`
+}
+
+type BatchOCRResponse struct {
+	Success        bool               `json:"success"`
+	Results        []BatchImageResult `json:"results"`
+	TotalImages    int                `json:"total_images"`
+	SucceededCount int                `json:"succeeded_count"`
+	FailedCount    int                `json:"failed_count"`
+	Message        string             `json:"message,omitempty"`
+	ErrorCode      string             `json:"error_code,omitempty"`
+}
+
+// batchImage is one image of a batch request. err is set when the image
+// could not be read at all.
+type batchImage struct {
+	filename string
+	data     []byte
+	err      error
+}
+
+var (
+	maxBatchImages = 20
+	// maxBatchArchiveBytes caps the uncompressed size of all archive
+	// entries in one request, so a small zip cannot expand without bound.
+	maxBatchArchiveBytes int64 = 200 << 20
+)
+
+func batchImageExtractHandler(c *gin.Context) {
+	requestStart := time.Now()
+	clientIP := c.ClientIP()
+	log.Printf("[HTTP BATCH REQUEST] Batch OCR extraction request received from client IP: %s", clientIP)
+
+	params, err := parseImageExtractParams(c)
+	if err != nil {
+		log.Printf("[HTTP BATCH REQUEST ERROR] Invalid request parameters: %v", err)
+		c.JSON(http.StatusBadRequest, BatchOCRResponse{Success: false, Message: err.Error(), ErrorCode: ErrorCodeInvalidParameter})
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Printf("[HTTP BATCH REQUEST ERROR] Failed to parse multipart form from client IP: %s, error: %v", clientIP, err)
+		if tooLarge := bodyTooLargeError(err); tooLarge != nil {
+			c.JSON(tooLarge.Status, BatchOCRResponse{Success: false, Message: tooLarge.Message, ErrorCode: tooLarge.Code})
+			return
+		}
+		c.JSON(http.StatusBadRequest, BatchOCRResponse{Success: false, Message: "multipart form with image parts or an archive is required", ErrorCode: ErrorCodeInvalidParameter})
+		return
+	}
+
+	images, err := collectBatchImages(form)
+	if err != nil {
+		log.Printf("[HTTP BATCH REQUEST ERROR] Failed to read batch images from client IP: %s, error: %v", clientIP, err)
+		c.JSON(http.StatusBadRequest, BatchOCRResponse{Success: false, Message: err.Error(), ErrorCode: ErrorCodeInvalidParameter})
+		return
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusBadRequest, BatchOCRResponse{Success: false, Message: "at least one image is required", ErrorCode: ErrorCodeInvalidParameter})
+		return
+	}
+	log.Printf("[HTTP BATCH REQUEST] Received %d images, filter type: '%s'", len(images), params.FilterType)
+
+	response := runBatchExtraction(c.Request.Context(), images, params)
+	if err := c.Request.Context().Err(); err != nil && abortedByContext(c, err) {
+		return
+	}
+
+	log.Printf("[HTTP BATCH REQUEST SUCCESS] Batch extraction completed in %v, client IP: %s, images: %d, succeeded: %d, failed: %d",
+		time.Since(requestStart), clientIP, response.TotalImages, response.SucceededCount, response.FailedCount)
+	c.JSON(http.StatusOK, response)
+}
+
+// batchFilterChunkItems is the most texts sent in one filter call, so that
+// a reply holding every text still fits in filterReplyMaxTokens.
+const batchFilterChunkItems = 80
+
+// runBatchExtraction runs OCR on every image and then applies the filter
+// over the combined texts, so a batch costs one LLM call per
+// batchFilterChunkItems texts instead of one per image.
+func runBatchExtraction(ctx context.Context, images []batchImage, params ImageExtractParams) BatchOCRResponse {
+	results := make([]BatchImageResult, len(images))
+	perImage := make([][]TextElement, len(images))
+	unfiltered := make([][]TextElement, len(images))
+	var combined []TextElement
+
+	extractions, errs := extractBatchImages(ctx, images, params.Options)
+	for i, img := range images {
+		results[i] = BatchImageResult{Index: i, Filename: img.filename}
+		if img.err != nil {
+			results[i].Result = OCRResponse{Success: false, Message: img.err.Error(), ErrorCode: inputErrorCode(img.err)}
+			continue
+		}
+
+		extraction, err := extractions[i], errs[i]
+		if err != nil {
+			log.Printf("[OCR BATCH] Image %d (%s) failed OCR: %v", i+1, img.filename, err)
+			results[i].Result = OCRResponse{Success: false, Message: extractionErrorMessage(err), ErrorCode: extractionErrorCode(err)}
+			continue
+		}
+
+		texts := extraction.Texts
+		results[i].Result.Success = true
+		results[i].Result.Orientation = extraction.Orientation
+		for _, text := range texts {
+			text.imageIndex = i
+			combined = append(combined, text)
+		}
+		perImage[i] = texts
+		unfiltered[i] = texts
+	}
+
+	if params.FilterType != "" && len(combined) > 0 {
+		for i := range perImage {
+			perImage[i] = nil
+		}
+		chunks := batchFilterChunks(combined, batchFilterChunkItems)
+		log.Printf("[OCR BATCH] Filtering %d texts in %d calls", len(combined), len(chunks))
+		for _, chunk := range chunks {
+			filtered, degraded, err := filterBatchChunk(ctx, params.FilterType, chunk)
+			if err != nil {
+				// Only the images in this chunk fail; an image split
+				// over several chunks fails if any of them does.
+				message, code := extractionErrorMessage(err), extractionErrorCode(err)
+				for _, text := range chunk {
+					if result := &results[text.imageIndex].Result; result.Success {
+						*result = OCRResponse{Success: false, Message: message, ErrorCode: code}
+					}
+				}
+				continue
+			}
+			for _, text := range filtered {
+				perImage[text.imageIndex] = append(perImage[text.imageIndex], text)
+			}
+			for _, text := range chunk {
+				if result := &results[text.imageIndex].Result; result.Success && degraded {
+					result.Degraded = true
+				}
+			}
+		}
+	}
+
+	response := BatchOCRResponse{Results: results, TotalImages: len(images)}
+	for i := range results {
+		if !results[i].Result.Success {
+			response.FailedCount++
+			continue
+		}
+
+		texts := perImage[i]
+		if texts == nil {
+			texts = []TextElement{}
+		}
+		if params.FilterType == "menu" {
+			results[i].Result.MenuItems = pairMenuPrices(texts, unfiltered[i])
+		}
+		if params.Layout == LayoutStructured {
+			results[i].Result.Layout = buildDocumentLayout(texts)
+		}
+		if params.Detail == "basic" {
+			texts = basicTextElements(texts)
+		}
+		results[i].Result.TextList = texts
+		results[i].Result.TotalCount = len(texts)
+		response.SucceededCount++
+	}
+
+	response.Success = response.SucceededCount > 0
+	if response.FailedCount > 0 {
+		response.Message = fmt.Sprintf("%d of %d images failed", response.FailedCount, response.TotalImages)
+	}
+	return response
+}
+
+// extractBatchImages runs OCR on the readable images with up to one worker
+// per admission slot. Each image waits in ocrQueue like a single image
+// request and then gets its own requestTimeout, so a large batch neither
+// holds one slot for all of its images nor shares one deadline between them.
+func extractBatchImages(ctx context.Context, images []batchImage, opts ExtractOptions) ([]*ExtractionResult, []error) {
+	extractions := make([]*ExtractionResult, len(images))
+	errs := make([]error, len(images))
+
+	workers := 1
+	if ocrQueue != nil {
+		workers = ocrQueue.MaxActive()
+	}
+	workers = min(workers, len(images))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				extractions[i], errs[i] = extractBatchImage(ctx, images[i].data, opts)
+			}
+		}()
+	}
+	for i, img := range images {
+		if img.err == nil {
+			indexes <- i
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return extractions, errs
+}
+
+func extractBatchImage(ctx context.Context, data []byte, opts ExtractOptions) (*ExtractionResult, error) {
+	if ocrQueue != nil {
+		release, err := ocrQueue.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return analyzer.ExtractTexts(ctx, data, opts)
+}
+
+// filterBatchChunk gives each filter call its own requestTimeout, since the
+// OCR stage may already have taken longer than that.
+func filterBatchChunk(ctx context.Context, filterType string, chunk []TextElement) ([]TextElement, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return applyTextFilter(ctx, filterType, chunk)
+}
+
+// batchFilterChunks splits texts, which are ordered by image, into chunks of
+// at most limit texts. Images are kept whole unless one alone holds more
+// than limit texts.
+func batchFilterChunks(texts []TextElement, limit int) [][]TextElement {
+	var chunks [][]TextElement
+	for start := 0; start < len(texts); {
+		end := start
+		for end < len(texts) {
+			next := end
+			for next < len(texts) && texts[next].imageIndex == texts[end].imageIndex {
+				next++
+			}
+			if next-start > limit {
+				if end == start {
+					end = start + limit
+				}
+				break
+			}
+			end = next
+		}
+		chunks = append(chunks, texts[start:end])
+		start = end
+	}
+	return chunks
+}
+
+// collectBatchImages reads every "image" part and every image inside a zip
+// "archive" part (or an "image" part that is itself a zip) into memory.
+func collectBatchImages(form *multipart.Form) ([]batchImage, error) {
+	var images []batchImage
+	archiveBudget := maxBatchArchiveBytes
+
+	headers := append(append([]*multipart.FileHeader{}, form.File["image"]...), form.File["archive"]...)
+	for _, header := range headers {
+		data, err := readMultipartFile(header)
+		if err != nil {
+			images = append(images, batchImage{filename: header.Filename, err: err})
+			continue
+		}
+
+		if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+			entries, err := readZipImages(data, &archiveBudget)
+			if err != nil {
+				return images, fmt.Errorf("invalid archive %s: %w", header.Filename, err)
+			}
+			images = append(images, entries...)
+		} else {
+			images = append(images, batchImage{filename: header.Filename, data: data, err: validateImageData(data)})
+		}
+
+		if len(images) > maxBatchImages {
+			return images, fmt.Errorf("batch contains more than %d images", maxBatchImages)
+		}
+	}
+
+	return images, nil
+}
+
+func readMultipartFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// readZipImages reads the images in a zip archive. budget is the number of
+// uncompressed bytes the request may still read from archives; the whole
+// archive is rejected once it runs out.
+func readZipImages(data []byte, budget *int64) ([]batchImage, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var images []batchImage
+	for _, entry := range reader.File {
+		name := entry.Name
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		if len(images) >= maxBatchImages {
+			return images, fmt.Errorf("archive contains more than %d images", maxBatchImages)
+		}
+		if entry.UncompressedSize64 > uint64(maxImageBytes) {
+			images = append(images, batchImage{filename: name, err: &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}})
+			continue
+		}
+		if int64(entry.UncompressedSize64) > *budget {
+			return images, fmt.Errorf("archives expand to more than %d bytes", maxBatchArchiveBytes)
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			images = append(images, batchImage{filename: name, err: fmt.Errorf("failed to open archive entry: %w", err)})
+			continue
+		}
+		// The declared size may lie, so the read itself is bounded too.
+		content, err := io.ReadAll(io.LimitReader(rc, min(maxImageBytes, *budget)+1))
+		rc.Close()
+		*budget -= int64(len(content))
+		if *budget < 0 {
+			return images, fmt.Errorf("archives expand to more than %d bytes", maxBatchArchiveBytes)
+		}
+		if err != nil {
+			images = append(images, batchImage{filename: name, err: fmt.Errorf("failed to read archive entry")})
+			continue
+		}
+
+		images = append(images, batchImage{filename: name, data: content, err: validateImageData(content)})
+	}
+
+	return images, nil
+}
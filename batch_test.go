@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestBatchFilterChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int
+		want   []int
+	}{
+		{name: "all fit in one call", counts: []int{10, 20, 30}, want: []int{60}},
+		{name: "images kept whole", counts: []int{50, 50, 50}, want: []int{50, 50, 50}},
+		{name: "exactly the limit", counts: []int{80, 1}, want: []int{80, 1}},
+		{name: "large image split", counts: []int{200}, want: []int{80, 80, 40}},
+		{name: "large image between small ones", counts: []int{10, 200, 5}, want: []int{10, 80, 80, 45}},
+		{name: "no texts", counts: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var texts []TextElement
+			for img, count := range tt.counts {
+				for i := 0; i < count; i++ {
+					texts = append(texts, TextElement{imageIndex: img})
+				}
+			}
+
+			var sizes []int
+			total := 0
+			for _, chunk := range batchFilterChunks(texts, 80) {
+				sizes = append(sizes, len(chunk))
+				total += len(chunk)
+			}
+			if !slices.Equal(sizes, tt.want) {
+				t.Errorf("chunk sizes = %v, want %v", sizes, tt.want)
+			}
+			if total != len(texts) {
+				t.Errorf("chunks hold %d texts, want %d", total, len(texts))
+			}
+		})
+	}
+}
+
+func TestReadZipImages(t *testing.T) {
+	image := whitePNG(t, 20, 10)
+	archive := func(entries ...[]byte) []byte {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for i, entry := range entries {
+			f, err := w.Create(string(rune('a'+i)) + ".png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(entry)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		imageBytes int64
+		budget     int64
+		wantImages int
+		wantFailed int
+		wantErr    bool
+	}{
+		{name: "within limits", data: archive(image, image), imageBytes: 1 << 20, budget: 1 << 20, wantImages: 2},
+		{name: "entry over image limit", data: archive(image, bytes.Repeat([]byte{0}, 4096)), imageBytes: 1024, budget: 1 << 20, wantImages: 2, wantFailed: 1},
+		{name: "archive over budget", data: archive(image, image), imageBytes: 1 << 20, budget: int64(len(image)) + 10, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(imageBytes int64) { maxImageBytes = imageBytes }(maxImageBytes)
+			maxImageBytes = tt.imageBytes
+
+			budget := tt.budget
+			images, err := readZipImages(tt.data, &budget)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected the archive to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(images) != tt.wantImages {
+				t.Fatalf("got %d images, want %d", len(images), tt.wantImages)
+			}
+			failed := 0
+			for _, img := range images {
+				if img.err != nil {
+					failed++
+				}
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("%d images failed, want %d", failed, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+var llmBreaker *CircuitBreaker
+
+// CircuitBreaker stops calls to a failing dependency. After threshold
+// consecutive failures it opens and rejects calls for cooldown; then it lets
+// a single trial call through and closes again if that call succeeds.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	opened    int64
+	rejected  int64
+	lastError string
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow reports whether a call may go ahead. A caller that was allowed must
+// report the outcome with Record.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+		log.Printf("[CIRCUIT BREAKER] Cooldown of %v elapsed, letting a trial call through", b.cooldown)
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		b.rejected++
+		return false
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// Record reports the outcome of an allowed call. A nil err is a success.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		if b.state != BreakerClosed {
+			log.Printf("[CIRCUIT BREAKER] Trial call succeeded, closing")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			b.opened++
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		log.Printf("[CIRCUIT BREAKER] Opening for %v after %d consecutive failures, last error: %v", b.cooldown, b.failures, err)
+	}
+}
+
+// Release ends an allowed call whose outcome says nothing about the
+// dependency, such as a cancelled request, without changing the state.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"state":                b.state,
+		"consecutive_failures": b.failures,
+		"times_opened":         b.opened,
+		"rejected_calls":       b.rejected,
+	}
+	if b.state == BreakerOpen {
+		stats["retry_at"] = b.openedAt.Add(b.cooldown)
+	}
+	if b.lastError != "" {
+		stats["last_error"] = b.lastError
+	}
+	return stats
+}
+
+// BreakerLLMProvider guards an LLM provider with a circuit breaker, so an
+// API that is down fails requests at once instead of after every retry.
+// Only failures that say the API is down or overloaded count against the
+// breaker: network errors, 408, 429 and 5xx. A request the API rejects,
+// such as one over the context length, says nothing about other requests,
+// and neither does a cancelled one.
+type BreakerLLMProvider struct {
+	provider LLMProvider
+	breaker  *CircuitBreaker
+}
+
+func NewBreakerLLMProvider(provider LLMProvider, breaker *CircuitBreaker) *BreakerLLMProvider {
+	return &BreakerLLMProvider{provider: provider, breaker: breaker}
+}
+
+func (p *BreakerLLMProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *BreakerLLMProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	if !p.breaker.Allow() {
+		return "", &LLMError{Kind: LLMErrorUnavailable, Provider: p.provider.Name(), Message: "LLM calls are suspended after repeated failures", Err: errCircuitOpen}
+	}
+
+	result, err := p.provider.Complete(ctx, req)
+	var llmErr *LLMError
+	switch {
+	case err == nil:
+		p.breaker.Record(nil)
+	case errors.As(err, &llmErr) && (llmErr.Kind == LLMErrorUnavailable || llmErr.Kind == LLMErrorRateLimited):
+		p.breaker.Record(err)
+	default:
+		p.breaker.Release()
+	}
+	return result, err
+}
+
+const (
+	LLMFallbackNone       = "none"
+	LLMFallbackUnfiltered = "unfiltered"
+	LLMFallbackHeuristic  = "heuristic"
+)
+
+var llmFallback = LLMFallbackHeuristic
+
+func loadLLMFallbackConfig() {
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv("LLM_FALLBACK"))); value {
+	case "":
+	case LLMFallbackNone, LLMFallbackUnfiltered, LLMFallbackHeuristic:
+		llmFallback = value
+	default:
+		log.Printf("[LLM CONFIG] Unknown LLM_FALLBACK %q, using %s", value, llmFallback)
+	}
+	log.Printf("[LLM CONFIG] Fallback when the LLM is unavailable: %s", llmFallback)
+}
+
+// degradedTextFilter stands in for a store or food filter whose LLM is
+// unavailable or rate limited, which includes an open breaker. It reports
+// false when the fallback is off and for every other error, so that a
+// missing API key, a rejected request or an unusable reply still reaches
+// the client. The heuristic fallback drops texts that hold nothing but a
+// price, phone number or other number and keeps the rest uncorrected.
+func degradedTextFilter(err error, texts []TextElement) ([]TextElement, bool) {
+	if llmFallback == LLMFallbackNone || !llmOutage(err) {
+		return nil, false
+	}
+	if llmFallback == LLMFallbackUnfiltered {
+		log.Printf("[LLM FALLBACK] LLM unavailable (%v), returning %d unfiltered texts", err, len(texts))
+		return texts, true
+	}
+
+	filtered := []TextElement{}
+	for _, text := range texts {
+		if hasNameText(text.Text) {
+			filtered = append(filtered, text)
+		}
+	}
+	log.Printf("[LLM FALLBACK] LLM unavailable (%v), heuristic filter kept %d of %d texts", err, len(filtered), len(texts))
+	return filtered, true
+}
+
+// llmOutage reports whether err is an LLM failure that waiting may fix.
+func llmOutage(err error) bool {
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) || errors.Is(err, errLLMNotConfigured) {
+		return false
+	}
+	return llmErr.Kind == LLMErrorUnavailable || llmErr.Kind == LLMErrorRateLimited
+}
+
+func hasNameText(text string) bool {
+	if _, span, ok := parsePrice(text); ok {
+		text = text[:span[0]] + text[span[1]:]
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("unavailable")
+
+	t.Run("opens after threshold consecutive failures", func(t *testing.T) {
+		breaker := NewCircuitBreaker(3, time.Hour)
+		for _, err := range []error{failure, failure, nil, failure, failure, failure} {
+			if !breaker.Allow() {
+				t.Fatal("breaker rejected a call before reaching the threshold")
+			}
+			breaker.Record(err)
+		}
+		if breaker.Allow() {
+			t.Fatal("breaker allowed a call while open")
+		}
+		stats := breaker.Stats()
+		if stats["state"] != BreakerOpen || stats["times_opened"] != int64(1) || stats["rejected_calls"] != int64(1) {
+			t.Errorf("stats = %v", stats)
+		}
+	})
+
+	t.Run("lets one trial call through after cooldown", func(t *testing.T) {
+		breaker := NewCircuitBreaker(1, 0)
+		breaker.Allow()
+		breaker.Record(failure)
+
+		if !breaker.Allow() {
+			t.Fatal("breaker rejected the trial call")
+		}
+		if breaker.Allow() {
+			t.Fatal("breaker allowed a second call during the trial")
+		}
+		breaker.Record(nil)
+		if state := breaker.Stats()["state"]; state != BreakerClosed {
+			t.Fatalf("state after a successful trial = %v, want %v", state, BreakerClosed)
+		}
+	})
+
+	t.Run("failed trial opens again", func(t *testing.T) {
+		breaker := NewCircuitBreaker(5, 0)
+		for i := 0; i < 5; i++ {
+			breaker.Allow()
+			breaker.Record(failure)
+		}
+		breaker.Allow()
+		breaker.Record(failure)
+		if stats := breaker.Stats(); stats["state"] != BreakerOpen || stats["times_opened"] != int64(2) {
+			t.Errorf("stats = %v", stats)
+		}
+	})
+
+	t.Run("release ends a trial without changing state", func(t *testing.T) {
+		breaker := NewCircuitBreaker(1, 0)
+		breaker.Allow()
+		breaker.Record(failure)
+
+		breaker.Allow()
+		breaker.Release()
+		if state := breaker.Stats()["state"]; state != BreakerHalfOpen {
+			t.Fatalf("state after release = %v, want %v", state, BreakerHalfOpen)
+		}
+		if !breaker.Allow() {
+			t.Fatal("breaker rejected a trial call after the previous one was released")
+		}
+	})
+}
+
+type failingLLMProvider struct {
+	err   error
+	calls int
+}
+
+func (p *failingLLMProvider) Name() string {
+	return "failing"
+}
+
+func (p *failingLLMProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	p.calls++
+	return "", p.err
+}
+
+func TestBreakerLLMProvider(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		opens bool
+	}{
+		{name: "unavailable", err: &LLMError{Kind: LLMErrorUnavailable}, opens: true},
+		{name: "rate limited", err: &LLMError{Kind: LLMErrorRateLimited}, opens: true},
+		{name: "bad response", err: &LLMError{Kind: LLMErrorBadResponse}},
+		{name: "cancelled", err: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &failingLLMProvider{err: tt.err}
+			provider := NewBreakerLLMProvider(base, NewCircuitBreaker(2, time.Hour))
+			for i := 0; i < 3; i++ {
+				provider.Complete(context.Background(), LLMRequest{})
+			}
+
+			wantCalls := 3
+			if tt.opens {
+				wantCalls = 2
+			}
+			if base.calls != wantCalls {
+				t.Errorf("provider called %d times, want %d", base.calls, wantCalls)
+			}
+		})
+	}
+
+	t.Run("open breaker answers unavailable", func(t *testing.T) {
+		breaker := NewCircuitBreaker(1, time.Hour)
+		breaker.Allow()
+		breaker.Record(errors.New("down"))
+
+		_, err := NewBreakerLLMProvider(&failingLLMProvider{}, breaker).Complete(context.Background(), LLMRequest{})
+		var llmErr *LLMError
+		if !errors.As(err, &llmErr) || llmErr.Kind != LLMErrorUnavailable || !errors.Is(err, errCircuitOpen) {
+			t.Errorf("err = %v, want an unavailable error wrapping errCircuitOpen", err)
+		}
+	})
+}
+
+func TestDegradedTextFilter(t *testing.T) {
+	texts := []TextElement{{Text: "빅맥세트"}, {Text: "5,500원"}, {Text: "콜라"}}
+	breaker := NewCircuitBreaker(1, time.Hour)
+	breaker.Allow()
+	breaker.Record(errors.New("down"))
+	_, breakerErr := NewBreakerLLMProvider(&failingLLMProvider{}, breaker).Complete(context.Background(), LLMRequest{})
+
+	tests := []struct {
+		name     string
+		fallback string
+		err      error
+		want     int
+		degraded bool
+	}{
+		{name: "unavailable", fallback: LLMFallbackHeuristic, err: &LLMError{Kind: LLMErrorUnavailable}, want: 2, degraded: true},
+		{name: "rate limited", fallback: LLMFallbackHeuristic, err: &LLMError{Kind: LLMErrorRateLimited}, want: 2, degraded: true},
+		{name: "breaker open", fallback: LLMFallbackHeuristic, err: breakerErr, want: 2, degraded: true},
+		{name: "unfiltered", fallback: LLMFallbackUnfiltered, err: &LLMError{Kind: LLMErrorUnavailable}, want: 3, degraded: true},
+		{name: "fallback off", fallback: LLMFallbackNone, err: &LLMError{Kind: LLMErrorUnavailable}},
+		{name: "bad response", fallback: LLMFallbackHeuristic, err: &LLMError{Kind: LLMErrorBadResponse, StatusCode: 401}},
+		{name: "missing api key", fallback: LLMFallbackHeuristic, err: &LLMError{Kind: LLMErrorUnavailable, Err: errLLMNotConfigured}},
+		{name: "not an llm error", fallback: LLMFallbackHeuristic, err: errors.New("LLM provider not configured")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(fallback string) { llmFallback = fallback }(llmFallback)
+			llmFallback = tt.fallback
+
+			got, degraded := degradedTextFilter(tt.err, texts)
+			if degraded != tt.degraded || len(got) != tt.want {
+				t.Errorf("got %d texts, degraded %t, want %d texts, degraded %t", len(got), degraded, tt.want, tt.degraded)
+			}
+		})
+	}
+}
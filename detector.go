@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// TextRegion is a detected text area. Box is the axis-aligned bounds of the
+// area, clipped to the image. Detectors that find rotated text also set
+// Center, Width, Height and Angle, the counter-clockwise rotation of the text
+// baseline in degrees.
+type TextRegion struct {
+	Box    image.Rectangle
+	Center image.Point
+	Width  int
+	Height int
+	Angle  float64
+	Score  float64
+}
+
+func (r TextRegion) Rotated() bool {
+	return r.Angle != 0
+}
+
+// TextDetector finds areas of an image that are likely to contain text.
+// Implementations must be safe for concurrent use.
+type TextDetector interface {
+	Name() string
+	Detect(img gocv.Mat) []TextRegion
+}
+
+// minRotationDegrees is the smallest angle worth straightening a region for.
+const minRotationDegrees = 2.0
+
+// HeuristicTextDetector finds text by closing Canny edges horizontally and
+// taking the bounding boxes of the resulting blobs. Size limits relative to
+// the image keep large signboard lettering while dropping page-sized blobs.
+type HeuristicTextDetector struct {
+	MinArea        float64
+	MaxAreaRatio   float64
+	MinWidth       int
+	MinHeight      int
+	MaxHeightRatio float64
+	KernelWidth    int
+	KernelHeight   int
+	Padding        int
+}
+
+func NewHeuristicTextDetector() *HeuristicTextDetector {
+	return &HeuristicTextDetector{
+		MinArea:        100,
+		MaxAreaRatio:   0.5,
+		MinWidth:       15,
+		MinHeight:      8,
+		MaxHeightRatio: 0.5,
+		KernelWidth:    10,
+		KernelHeight:   2,
+		Padding:        5,
+	}
+}
+
+func (d *HeuristicTextDetector) Name() string {
+	return "heuristic"
+}
+
+func (d *HeuristicTextDetector) Detect(img gocv.Mat) []TextRegion {
+	log.Printf("[OCR REGION DETECTION] Starting heuristic text region detection for image size %dx%d", img.Cols(), img.Rows())
+
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
+
+	edges := gocv.NewMat()
+	defer edges.Close()
+	gocv.Canny(gray, &edges, 50, 150)
+
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Pt(d.KernelWidth, d.KernelHeight))
+	defer kernel.Close()
+
+	connected := gocv.NewMat()
+	defer connected.Close()
+	gocv.MorphologyEx(edges, &connected, gocv.MorphClose, kernel)
+
+	contours := gocv.FindContours(connected, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	defer contours.Close()
+
+	log.Printf("[OCR REGION DETECTION] Found %d contours for analysis", contours.Size())
+
+	maxArea := d.MaxAreaRatio * float64(img.Cols()*img.Rows())
+	maxHeight := int(d.MaxHeightRatio * float64(img.Rows()))
+
+	var regions []TextRegion
+	for i := 0; i < contours.Size(); i++ {
+		contour := contours.At(i)
+		area := gocv.ContourArea(contour)
+
+		if area > d.MinArea && area < maxArea {
+			rect := gocv.BoundingRect(contour)
+
+			if rect.Dx() > d.MinWidth && rect.Dy() > d.MinHeight && rect.Dy() < maxHeight {
+				expandedRect := image.Rect(rect.Min.X-d.Padding, rect.Min.Y-d.Padding, rect.Max.X+d.Padding, rect.Max.Y+d.Padding).Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+				regions = append(regions, TextRegion{Box: expandedRect, Score: -1})
+				log.Printf("[OCR REGION DETECTION] Valid region %d: area=%.0f, bounds=(%d,%d)-(%d,%d), expanded=(%d,%d)-(%d,%d)",
+					len(regions), area, rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y,
+					expandedRect.Min.X, expandedRect.Min.Y, expandedRect.Max.X, expandedRect.Max.Y)
+			} else {
+				log.Printf("[OCR REGION DETECTION] Rejected contour %d: area=%.0f, size=%dx%d (too small/large)", i+1, area, rect.Dx(), rect.Dy())
+			}
+		} else {
+			log.Printf("[OCR REGION DETECTION] Rejected contour %d: area=%.0f (outside valid range %.0f-%.0f)", i+1, area, d.MinArea, maxArea)
+		}
+	}
+
+	log.Printf("[OCR REGION DETECTION] Region detection completed, found %d valid text regions", len(regions))
+	return regions
+}
+
+const (
+	DetectorModelEAST = "east"
+	DetectorModelDB   = "db"
+)
+
+// DNNTextDetector runs an EAST or DBNet text detection model through the
+// OpenCV DNN module. EAST expects the frozen TensorFlow graph
+// (frozen_east_text_detection.pb); DB expects an ONNX export such as
+// DB_TD500_resnet50.onnx. OpenCV networks are not safe for concurrent use,
+// so inference is serialized.
+type DNNTextDetector struct {
+	kind           string
+	modelPath      string
+	inputSize      image.Point
+	scoreThreshold float32
+	nmsThreshold   float32
+
+	mu  sync.Mutex
+	net gocv.Net
+}
+
+func NewDNNTextDetector(kind, modelPath string, inputSize image.Point, scoreThreshold, nmsThreshold float32) (*DNNTextDetector, error) {
+	if kind != DetectorModelEAST && kind != DetectorModelDB {
+		return nil, fmt.Errorf("unknown text detection model %q", kind)
+	}
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("text detection model not found: %w", err)
+	}
+	if inputSize.X%32 != 0 || inputSize.Y%32 != 0 || inputSize.X <= 0 || inputSize.Y <= 0 {
+		return nil, fmt.Errorf("detector input size %dx%d must be a positive multiple of 32", inputSize.X, inputSize.Y)
+	}
+
+	net := gocv.ReadNet(modelPath, "")
+	if net.Empty() {
+		return nil, fmt.Errorf("failed to load text detection model %s", modelPath)
+	}
+
+	log.Printf("[OCR DETECTOR] Loaded %s text detection model from %s, input size %dx%d", kind, modelPath, inputSize.X, inputSize.Y)
+	return &DNNTextDetector{
+		kind:           kind,
+		modelPath:      modelPath,
+		inputSize:      inputSize,
+		scoreThreshold: scoreThreshold,
+		nmsThreshold:   nmsThreshold,
+		net:            net,
+	}, nil
+}
+
+func (d *DNNTextDetector) Name() string {
+	return d.kind + ":" + d.modelPath
+}
+
+func (d *DNNTextDetector) Detect(img gocv.Mat) []TextRegion {
+	log.Printf("[OCR REGION DETECTION] Starting %s text region detection for image size %dx%d", d.kind, img.Cols(), img.Rows())
+
+	var regions []TextRegion
+	var err error
+	switch d.kind {
+	case DetectorModelEAST:
+		regions, err = d.detectEAST(img)
+	case DetectorModelDB:
+		regions, err = d.detectDB(img)
+	}
+	if err != nil {
+		log.Printf("[OCR REGION DETECTION] %s detection failed: %v", d.kind, err)
+		return nil
+	}
+
+	log.Printf("[OCR REGION DETECTION] Region detection completed, found %d valid text regions", len(regions))
+	return regions
+}
+
+// detectEAST decodes the score and geometry maps of EAST, which predict one
+// rotated box per 4x4 cell, and suppresses overlapping boxes.
+func (d *DNNTextDetector) detectEAST(img gocv.Mat) ([]TextRegion, error) {
+	blob := gocv.BlobFromImage(img, 1.0, d.inputSize, gocv.NewScalar(123.68, 116.78, 103.94, 0), true, false)
+	defer blob.Close()
+
+	d.mu.Lock()
+	d.net.SetInput(blob, "")
+	outputs := d.net.ForwardLayers([]string{"feature_fusion/Conv_7/Sigmoid", "feature_fusion/concat_3"})
+	d.mu.Unlock()
+	defer func() {
+		for i := range outputs {
+			outputs[i].Close()
+		}
+	}()
+	if len(outputs) != 2 {
+		return nil, fmt.Errorf("expected 2 EAST outputs, got %d", len(outputs))
+	}
+
+	scores, err := outputs[0].DataPtrFloat32()
+	if err != nil {
+		return nil, err
+	}
+	geometry, err := outputs[1].DataPtrFloat32()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, cols := d.inputSize.Y/4, d.inputSize.X/4
+	plane := rows * cols
+	if len(scores) < plane || len(geometry) < 5*plane {
+		return nil, fmt.Errorf("unexpected EAST output size")
+	}
+	scaleX := float64(img.Cols()) / float64(d.inputSize.X)
+	scaleY := float64(img.Rows()) / float64(d.inputSize.Y)
+
+	var candidates []TextRegion
+	var boxes []image.Rectangle
+	var confidences []float32
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			score := scores[y*cols+x]
+			if score < d.scoreThreshold {
+				continue
+			}
+			idx := y*cols + x
+			top, right, bottom, left := float64(geometry[idx]), float64(geometry[plane+idx]), float64(geometry[2*plane+idx]), float64(geometry[3*plane+idx])
+			angle := float64(geometry[4*plane+idx])
+			cos, sin := math.Cos(angle), math.Sin(angle)
+			height, width := top+bottom, right+left
+
+			offsetX := float64(x*4) + cos*right + sin*bottom
+			offsetY := float64(y*4) - sin*right + cos*bottom
+			p1x, p1y := -sin*height+offsetX, -cos*height+offsetY
+			p3x, p3y := -cos*width+offsetX, sin*width+offsetY
+			centerX, centerY := (p1x+p3x)/2, (p1y+p3y)/2
+
+			region := rotatedTextRegion(centerX*scaleX, centerY*scaleY, width*scaleX, height*scaleY, angle*180/math.Pi, img)
+			region.Score = float64(score)
+			candidates = append(candidates, region)
+			boxes = append(boxes, region.Box)
+			confidences = append(confidences, score)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	var regions []TextRegion
+	for _, i := range gocv.NMSBoxes(boxes, confidences, d.scoreThreshold, d.nmsThreshold) {
+		regions = append(regions, candidates[i])
+	}
+	return regions, nil
+}
+
+// detectDB thresholds the DBNet probability map, fits a rotated rectangle to
+// every connected area and grows it by the usual unclip ratio, since DB
+// predicts shrunken text kernels.
+func (d *DNNTextDetector) detectDB(img gocv.Mat) ([]TextRegion, error) {
+	const binaryThreshold = 0.3
+	const unclipRatio = 1.5
+
+	blob := gocv.BlobFromImage(img, 1.0/255, d.inputSize, gocv.NewScalar(122.67891434, 116.66876762, 104.00698793, 0), false, false)
+	defer blob.Close()
+
+	d.mu.Lock()
+	d.net.SetInput(blob, "")
+	output := d.net.Forward("")
+	d.mu.Unlock()
+	defer output.Close()
+
+	probabilities, err := output.DataPtrFloat32()
+	if err != nil {
+		return nil, err
+	}
+	rows, cols := d.inputSize.Y, d.inputSize.X
+	if len(probabilities) < rows*cols {
+		return nil, fmt.Errorf("unexpected DB output size")
+	}
+
+	mask := gocv.NewMatWithSize(rows, cols, gocv.MatTypeCV8U)
+	defer mask.Close()
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			if probabilities[y*cols+x] > binaryThreshold {
+				mask.SetUCharAt(y, x, 255)
+			} else {
+				mask.SetUCharAt(y, x, 0)
+			}
+		}
+	}
+
+	contours := gocv.FindContours(mask, gocv.RetrievalList, gocv.ChainApproxSimple)
+	defer contours.Close()
+
+	scaleX := float64(img.Cols()) / float64(cols)
+	scaleY := float64(img.Rows()) / float64(rows)
+
+	var regions []TextRegion
+	for i := 0; i < contours.Size(); i++ {
+		contour := contours.At(i)
+		if contour.Size() < 4 {
+			continue
+		}
+
+		bounds := gocv.BoundingRect(contour).Intersect(image.Rect(0, 0, cols, rows))
+		var sum float64
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				sum += float64(probabilities[y*cols+x])
+			}
+		}
+		score := sum / float64(max(1, bounds.Dx()*bounds.Dy()))
+		if score < float64(d.scoreThreshold) {
+			continue
+		}
+
+		box := gocv.MinAreaRect(contour)
+		width, height := float64(box.Width), float64(box.Height)
+		if min(width, height) < 3 {
+			continue
+		}
+		distance := width * height * unclipRatio / (2 * (width + height))
+		width, height = width+2*distance, height+2*distance
+
+		angle := -box.Angle
+		if width < height {
+			width, height = height, width
+			angle += 90
+		}
+		if angle > 90 {
+			angle -= 180
+		} else if angle <= -90 {
+			angle += 180
+		}
+
+		region := rotatedTextRegion(float64(box.Center.X)*scaleX, float64(box.Center.Y)*scaleY, width*scaleX, height*scaleY, angle, img)
+		region.Score = score
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// rotatedTextRegion builds a region from a rotated box in image pixels.
+// Nearly horizontal boxes are treated as upright.
+func rotatedTextRegion(centerX, centerY, width, height, angle float64, img gocv.Mat) TextRegion {
+	if math.Abs(angle) < minRotationDegrees {
+		angle = 0
+	}
+	radians := angle * math.Pi / 180
+	cos, sin := math.Abs(math.Cos(radians)), math.Abs(math.Sin(radians))
+	halfW := (width*cos + height*sin) / 2
+	halfH := (width*sin + height*cos) / 2
+
+	box := image.Rect(int(centerX-halfW), int(centerY-halfH), int(math.Ceil(centerX+halfW)), int(math.Ceil(centerY+halfH)))
+	return TextRegion{
+		Box:    box.Intersect(image.Rect(0, 0, img.Cols(), img.Rows())),
+		Center: image.Pt(int(centerX), int(centerY)),
+		Width:  int(math.Round(width)),
+		Height: int(math.Round(height)),
+		Angle:  angle,
+	}
+}
+
+// cropTextRegion returns the pixels of a region with its text straightened
+// to horizontal. The caller must close the returned Mat.
+func cropTextRegion(img gocv.Mat, region TextRegion) gocv.Mat {
+	if !region.Rotated() {
+		return img.Region(region.Box)
+	}
+
+	radius := int(math.Ceil(math.Hypot(float64(region.Width), float64(region.Height))/2)) + 1
+	around := image.Rect(region.Center.X-radius, region.Center.Y-radius, region.Center.X+radius, region.Center.Y+radius).Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	if around.Empty() {
+		return gocv.NewMat()
+	}
+	roi := img.Region(around)
+	defer roi.Close()
+
+	center := region.Center.Sub(around.Min)
+	matrix := gocv.GetRotationMatrix2D(center, -region.Angle, 1)
+	defer matrix.Close()
+
+	straightened := gocv.NewMat()
+	defer straightened.Close()
+	gocv.WarpAffineWithParams(roi, &straightened, matrix, image.Pt(roi.Cols(), roi.Rows()), gocv.InterpolationLinear, gocv.BorderReplicate, color.RGBA{})
+
+	text := image.Rect(center.X-region.Width/2, center.Y-region.Height/2, center.X+(region.Width+1)/2, center.Y+(region.Height+1)/2).Intersect(image.Rect(0, 0, straightened.Cols(), straightened.Rows()))
+	if text.Empty() {
+		return gocv.NewMat()
+	}
+	view := straightened.Region(text)
+	defer view.Close()
+	return view.Clone()
+}
+
+func NewTextDetectorFromEnv() (TextDetector, error) {
+	switch detectorName := os.Getenv("OCR_DETECTOR"); detectorName {
+	case "", "heuristic":
+		detector := NewHeuristicTextDetector()
+		detector.MinArea = getEnvFloat("OCR_REGION_MIN_AREA", detector.MinArea)
+		detector.MaxAreaRatio = getEnvFloat("OCR_REGION_MAX_AREA_RATIO", detector.MaxAreaRatio)
+		detector.MinWidth = getEnvInt("OCR_REGION_MIN_WIDTH", detector.MinWidth)
+		detector.MinHeight = getEnvInt("OCR_REGION_MIN_HEIGHT", detector.MinHeight)
+		detector.MaxHeightRatio = getEnvFloat("OCR_REGION_MAX_HEIGHT_RATIO", detector.MaxHeightRatio)
+		return detector, nil
+	case DetectorModelEAST, DetectorModelDB:
+		modelPath := os.Getenv("OCR_DETECTOR_MODEL")
+		if modelPath == "" {
+			return nil, fmt.Errorf("OCR_DETECTOR_MODEL is required for the %s detector", detectorName)
+		}
+		defaultSize := 320
+		if detectorName == DetectorModelDB {
+			defaultSize = 736
+		}
+		inputSize := image.Pt(getEnvInt("OCR_DETECTOR_INPUT_WIDTH", defaultSize), getEnvInt("OCR_DETECTOR_INPUT_HEIGHT", defaultSize))
+		return NewDNNTextDetector(
+			detectorName,
+			modelPath,
+			inputSize,
+			float32(getEnvFloat("OCR_DETECTOR_SCORE_THRESHOLD", 0.5)),
+			float32(getEnvFloat("OCR_DETECTOR_NMS_THRESHOLD", 0.4)),
+		)
+	default:
+		return nil, fmt.Errorf("unknown text detector %q", detectorName)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	EnsembleOff            = ""
+	EnsembleVoteConfidence = "confidence"
+	EnsembleVoteAgreement  = "agreement"
+)
+
+const (
+	SourceFullImageEnsemble = "full_ensemble"
+	SourceRegionEnsemble    = "region_ensemble"
+)
+
+var (
+	ensembleProfiles []*PreprocessProfile
+	ensemblePSMs     = []string{"7", "8"}
+)
+
+// loadEnsembleConfig resolves OCR_ENSEMBLE_PROFILES against the loaded
+// preprocessing profiles, so it must run after loadPreprocessConfig.
+func loadEnsembleConfig() error {
+	names := []string{DefaultPreprocessProfile, "menu", "signboard"}
+	if value := os.Getenv("OCR_ENSEMBLE_PROFILES"); value != "" {
+		names = splitList(value)
+	}
+	if value := os.Getenv("OCR_ENSEMBLE_PSMS"); value != "" {
+		ensemblePSMs = splitList(value)
+	}
+
+	ensembleProfiles = nil
+	for _, name := range names {
+		profile, ok := preprocessProfiles[name]
+		if !ok {
+			return fmt.Errorf("ensemble profile %q is not defined", name)
+		}
+		ensembleProfiles = append(ensembleProfiles, profile)
+	}
+	if len(ensembleProfiles) == 0 || len(ensemblePSMs) == 0 {
+		return fmt.Errorf("ensemble needs at least one profile and one PSM")
+	}
+
+	log.Printf("[ENSEMBLE CONFIG] Profiles: %v, PSM modes: %v, variants per region: %d", names, ensemblePSMs, len(ensembleProfiles)*len(ensemblePSMs))
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ocrCandidate is what one preprocessing/PSM variant read.
+type ocrCandidate struct {
+	Variant string
+	Result  OCRResult
+}
+
+// recognizeRegionEnsemble reads a region once per configured profile and
+// PSM combination and keeps the reading chosen by vote. Each profile is run
+// once and shared by all PSM modes.
+func (ocr *OCRAnalyzer) recognizeRegionEnsemble(ctx context.Context, img gocv.Mat, region TextRegion, vote string) OCRResult {
+	roi := cropTextRegion(img, region)
+	defer roi.Close()
+	if roi.Empty() {
+		log.Printf("[OCR ENSEMBLE] Region ROI is empty, skipping recognition")
+		return OCRResult{Confidence: -1}
+	}
+
+	var candidates []ocrCandidate
+	for _, profile := range ensembleProfiles {
+		processed := profile.Run(roi)
+		for _, psm := range ensemblePSMs {
+			if ctx.Err() != nil {
+				processed.Close()
+				return OCRResult{Confidence: -1}
+			}
+			result, err := ocr.engine.Recognize(ctx, processed, OCROptions{PSM: psm})
+			variant := profile.Name + "/psm" + psm
+			if err != nil {
+				log.Printf("[OCR ENSEMBLE] Variant %s failed for region: %v", variant, err)
+				continue
+			}
+			log.Printf("[OCR ENSEMBLE] Variant %s read '%s', confidence: %.1f", variant, result.Text, result.Confidence)
+			candidates = append(candidates, ocrCandidate{Variant: variant, Result: result})
+		}
+		processed.Close()
+	}
+
+	best, variant, agreeing := voteOCRCandidates(candidates, vote)
+	if variant != "" {
+		log.Printf("[OCR ENSEMBLE] Region (%d,%d)-(%d,%d) picked variant %s by %s vote: '%s', confidence: %.1f, agreeing variants: %d/%d",
+			region.Box.Min.X, region.Box.Min.Y, region.Box.Max.X, region.Box.Max.Y, variant, vote, best.Text, best.Confidence, agreeing, len(candidates))
+	}
+	return best
+}
+
+// voteOCRCandidates picks the best reading among candidates. A confidence
+// vote takes the most confident non-empty reading. An agreement vote groups
+// readings that match after normalizing case and whitespace, takes the
+// largest group and breaks ties by confidence. It returns the chosen result,
+// the variant that produced it and how many variants agreed with it.
+func voteOCRCandidates(candidates []ocrCandidate, vote string) (OCRResult, string, int) {
+	groups := make(map[string][]int)
+	var order []string
+	for i, candidate := range candidates {
+		key := strings.ToLower(strings.Join(strings.Fields(candidate.Result.Text), " "))
+		if key == "" {
+			continue
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	if len(order) == 0 {
+		return OCRResult{Confidence: -1}, "", 0
+	}
+
+	bestIn := func(members []int) int {
+		best := members[0]
+		for _, i := range members[1:] {
+			if candidates[i].Result.Confidence > candidates[best].Result.Confidence {
+				best = i
+			}
+		}
+		return best
+	}
+
+	bestKey := order[0]
+	for _, key := range order[1:] {
+		current, challenger := candidates[bestIn(groups[bestKey])].Result.Confidence, candidates[bestIn(groups[key])].Result.Confidence
+		switch vote {
+		case EnsembleVoteAgreement:
+			if len(groups[key]) > len(groups[bestKey]) || (len(groups[key]) == len(groups[bestKey]) && challenger > current) {
+				bestKey = key
+			}
+		default:
+			if challenger > current {
+				bestKey = key
+			}
+		}
+	}
+
+	winner := bestIn(groups[bestKey])
+	return candidates[winner].Result, candidates[winner].Variant, len(groups[bestKey])
+}
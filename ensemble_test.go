@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestVoteOCRCandidates(t *testing.T) {
+	candidate := func(variant, text string, confidence float64) ocrCandidate {
+		return ocrCandidate{Variant: variant, Result: OCRResult{Text: text, Confidence: confidence}}
+	}
+	candidates := []ocrCandidate{
+		candidate("default/psm7", "빅맥세트", 70),
+		candidate("menu/psm7", "비맥세트", 90),
+		candidate("menu/psm8", " 빅맥세트 ", 80),
+		candidate("signboard/psm7", "", 95),
+	}
+
+	tests := []struct {
+		name       string
+		candidates []ocrCandidate
+		vote       string
+		text       string
+		variant    string
+		agreeing   int
+	}{
+		{
+			name:       "confidence takes the most confident reading",
+			candidates: candidates,
+			vote:       EnsembleVoteConfidence,
+			text:       "비맥세트",
+			variant:    "menu/psm7",
+			agreeing:   1,
+		},
+		{
+			name:       "agreement takes the largest group",
+			candidates: candidates,
+			vote:       EnsembleVoteAgreement,
+			text:       " 빅맥세트 ",
+			variant:    "menu/psm8",
+			agreeing:   2,
+		},
+		{
+			name: "agreement ties broken by confidence",
+			candidates: []ocrCandidate{
+				candidate("a", "C0LA", 60),
+				candidate("b", "콜라", 85),
+				candidate("c", "cola", 75),
+				candidate("d", "콜 라", 50),
+			},
+			vote:     EnsembleVoteAgreement,
+			text:     "콜라",
+			variant:  "b",
+			agreeing: 1,
+		},
+		{
+			name: "agreement groups ignore case and spacing",
+			candidates: []ocrCandidate{
+				candidate("a", "Big  Mac", 40),
+				candidate("b", "big mac", 50),
+				candidate("c", "Bigmac", 90),
+			},
+			vote:     EnsembleVoteAgreement,
+			text:     "big mac",
+			variant:  "b",
+			agreeing: 2,
+		},
+		{
+			name:       "only empty readings",
+			candidates: []ocrCandidate{candidate("a", " ", 90)},
+			vote:       EnsembleVoteConfidence,
+		},
+		{
+			name: "no candidates",
+			vote: EnsembleVoteAgreement,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, variant, agreeing := voteOCRCandidates(tt.candidates, tt.vote)
+			if result.Text != tt.text || variant != tt.variant || agreeing != tt.agreeing {
+				t.Errorf("got %q from %q with %d agreeing, want %q from %q with %d", result.Text, variant, agreeing, tt.text, tt.variant, tt.agreeing)
+			}
+			if variant == "" && result.Confidence != -1 {
+				t.Errorf("empty vote confidence = %v, want -1", result.Confidence)
+			}
+		})
+	}
+}
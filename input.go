@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageInputRequest is the JSON alternative to a multipart upload. Exactly
+// one of ImageURL and ImageBase64 must be set.
+type ImageInputRequest struct {
+	ImageURL    string `json:"image_url"`
+	ImageBase64 string `json:"image_base64"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type imageInput struct {
+	Data        []byte
+	Source      string
+	CallbackURL string
+}
+
+// inputError is a client-facing problem with the submitted image, carrying
+// the HTTP status to answer with and an optional machine-readable code.
+type inputError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *inputError) Error() string {
+	return e.Message
+}
+
+var (
+	maxImageBytes     int64 = 20 << 20
+	imageFetchTimeout       = 10 * time.Second
+	imageFetchHosts   []string
+	imageFetchClient  = &http.Client{Timeout: imageFetchTimeout}
+)
+
+func loadImageInputConfig() {
+	maxImageBytes = int64(getEnvInt("MAX_IMAGE_BYTES", int(maxImageBytes)))
+	imageFetchTimeout = time.Duration(getEnvInt("IMAGE_FETCH_TIMEOUT_SECONDS", int(imageFetchTimeout.Seconds()))) * time.Second
+	imageFetchClient = &http.Client{
+		Timeout: imageFetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return fmt.Errorf("too many redirects")
+			}
+			if !isAllowedFetchHost(req.URL.Hostname()) {
+				return fmt.Errorf("redirect to host %s is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+
+	imageFetchHosts = parseHostList(os.Getenv("IMAGE_FETCH_ALLOWED_HOSTS"))
+	log.Printf("[IMAGE INPUT CONFIG] Max image size: %d bytes, fetch timeout: %v, allowed fetch hosts: %v", maxImageBytes, imageFetchTimeout, imageFetchHosts)
+}
+
+// readImageInput reads the request image from a multipart "image" part or,
+// for JSON requests, from image_url or image_base64.
+func readImageInput(c *gin.Context) (*imageInput, error) {
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		var req ImageInputRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if tooLarge := bodyTooLargeError(err); tooLarge != nil {
+				return nil, tooLarge
+			}
+			return nil, &inputError{Status: http.StatusBadRequest, Message: "invalid JSON body: " + err.Error()}
+		}
+
+		var input *imageInput
+		var err error
+		switch {
+		case req.ImageURL != "" && req.ImageBase64 != "":
+			return nil, &inputError{Status: http.StatusBadRequest, Message: "only one of image_url and image_base64 may be set"}
+		case req.ImageURL != "":
+			input, err = fetchImageURL(c, req.ImageURL)
+		case req.ImageBase64 != "":
+			input, err = decodeImageBase64(req.ImageBase64)
+		default:
+			return nil, &inputError{Status: http.StatusBadRequest, Message: "image_url or image_base64 is required"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		input.CallbackURL = req.CallbackURL
+		return input, validateImageData(input.Data)
+	}
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		if tooLarge := bodyTooLargeError(err); tooLarge != nil {
+			return nil, tooLarge
+		}
+		return nil, &inputError{Status: http.StatusBadRequest, Message: "Image file required"}
+	}
+	if file.Size > maxImageBytes {
+		return nil, &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}
+	}
+
+	log.Printf("[IMAGE INPUT] Image file received: filename='%s', size=%d bytes, content-type='%s'", file.Filename, file.Size, file.Header.Get("Content-Type"))
+	data, err := readMultipartFile(file)
+	if err != nil {
+		return nil, &inputError{Status: http.StatusBadRequest, Message: "Failed to read image"}
+	}
+
+	input := &imageInput{Data: data, Source: "upload:" + file.Filename, CallbackURL: c.PostForm("callback_url")}
+	return input, validateImageData(input.Data)
+}
+
+func decodeImageBase64(encoded string) (*imageInput, error) {
+	if strings.HasPrefix(encoded, "data:") {
+		if comma := strings.Index(encoded, ","); comma >= 0 {
+			encoded = encoded[comma+1:]
+		}
+	}
+	encoded = strings.TrimSpace(encoded)
+
+	if int64(base64.StdEncoding.DecodedLen(len(encoded))) > maxImageBytes+3 {
+		return nil, &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	}
+	if err != nil {
+		return nil, &inputError{Status: http.StatusBadRequest, Message: "image_base64 is not valid base64"}
+	}
+	if int64(len(data)) > maxImageBytes {
+		return nil, &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}
+	}
+
+	log.Printf("[IMAGE INPUT] Decoded base64 image of %d bytes", len(data))
+	return &imageInput{Data: data, Source: "base64"}, nil
+}
+
+func fetchImageURL(c *gin.Context, rawURL string) (*imageInput, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, &inputError{Status: http.StatusBadRequest, Message: "image_url must be an absolute http or https URL"}
+	}
+	if !isAllowedFetchHost(parsed.Hostname()) {
+		log.Printf("[IMAGE INPUT] Rejected fetch from host %s, not in allowlist", parsed.Hostname())
+		return nil, &inputError{Status: http.StatusForbidden, Message: fmt.Sprintf("fetching images from host %s is not allowed", parsed.Hostname())}
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, &inputError{Status: http.StatusBadRequest, Message: "invalid image_url"}
+	}
+
+	startTime := time.Now()
+	resp, err := imageFetchClient.Do(req)
+	if err != nil {
+		log.Printf("[IMAGE INPUT] Fetching %s failed after %v: %v", parsed.Redacted(), time.Since(startTime), err)
+		return nil, &inputError{Status: http.StatusBadGateway, Message: "failed to fetch image_url"}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[IMAGE INPUT] Fetching %s returned status %d", parsed.Redacted(), resp.StatusCode)
+		return nil, &inputError{Status: http.StatusBadGateway, Message: fmt.Sprintf("image_url returned status %d", resp.StatusCode)}
+	}
+	if resp.ContentLength > maxImageBytes {
+		return nil, &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, &inputError{Status: http.StatusBadGateway, Message: "failed to read image_url response"}
+	}
+	if int64(len(data)) > maxImageBytes {
+		return nil, &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}
+	}
+
+	log.Printf("[IMAGE INPUT] Fetched %d bytes from %s in %v", len(data), parsed.Redacted(), time.Since(startTime))
+	return &imageInput{Data: data, Source: "url:" + parsed.Redacted()}, nil
+}
+
+// isAllowedFetchHost matches a host against IMAGE_FETCH_ALLOWED_HOSTS. An
+// empty list allows nothing, so URL input stays disabled until hosts are
+// configured.
+func isAllowedFetchHost(host string) bool {
+	return matchesHostList(host, imageFetchHosts)
+}
+
+func parseHostList(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// matchesHostList reports whether host is in hosts. Entries starting with
+// "*." match any subdomain.
+func matchesHostList(host string, hosts []string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range hosts {
+		if host == allowed {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+type OCRJob struct {
+	ID             string       `json:"id"`
+	Status         JobStatus    `json:"status"`
+	FilterType     string       `json:"type,omitempty"`
+	Result         *OCRResponse `json:"result,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	CallbackURL    string       `json:"callback_url,omitempty"`
+	CallbackStatus string       `json:"callback_status,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+	ExpiresAt      time.Time    `json:"expires_at"`
+
+	imageData []byte
+	params    ImageExtractParams
+}
+
+// JobStore keeps OCR jobs between submission and expiry. Implementations
+// must be safe for concurrent use and return copies so callers can modify
+// jobs without holding a lock.
+type JobStore interface {
+	Create(job *OCRJob) error
+	Get(id string) (*OCRJob, error)
+	Update(job *OCRJob) error
+	Delete(id string)
+	DeleteExpired(now time.Time) []*OCRJob
+}
+
+var errJobNotFound = fmt.Errorf("job not found")
+
+type MemoryJobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*OCRJob
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: make(map[string]*OCRJob)}
+}
+
+func (s *MemoryJobStore) Create(job *OCRJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[job.ID]; exists {
+		return fmt.Errorf("job %s already exists", job.ID)
+	}
+	stored := *job
+	s.jobs[job.ID] = &stored
+	return nil
+}
+
+func (s *MemoryJobStore) Get(id string) (*OCRJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored, ok := s.jobs[id]
+	if !ok || time.Now().After(stored.ExpiresAt) {
+		return nil, errJobNotFound
+	}
+	job := *stored
+	return &job, nil
+}
+
+func (s *MemoryJobStore) Update(job *OCRJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; !ok {
+		return errJobNotFound
+	}
+	stored := *job
+	s.jobs[job.ID] = &stored
+	return nil
+}
+
+func (s *MemoryJobStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+}
+
+func (s *MemoryJobStore) DeleteExpired(now time.Time) []*OCRJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []*OCRJob
+	for id, job := range s.jobs {
+		if now.After(job.ExpiresAt) {
+			expired = append(expired, job)
+			delete(s.jobs, id)
+		}
+	}
+	return expired
+}
+
+// JobRunner executes submitted jobs on a fixed number of workers. Jobs that
+// do not fit in the pending queue are rejected at submission time. Each job
+// may run for timeout, which is longer than the synchronous request timeout
+// since jobs exist for images too large to finish within it.
+type JobRunner struct {
+	store   JobStore
+	pending chan string
+	ttl     time.Duration
+	timeout time.Duration
+	client  *http.Client
+}
+
+var errJobQueueFull = fmt.Errorf("job queue is full")
+
+func NewJobRunner(store JobStore, workers, queueSize int, ttl, timeout time.Duration) *JobRunner {
+	runner := &JobRunner{
+		store:   store,
+		pending: make(chan string, max(queueSize, 1)),
+		ttl:     ttl,
+		timeout: timeout,
+		client:  newCallbackClient(),
+	}
+
+	for i := 0; i < max(workers, 1); i++ {
+		go runner.work()
+	}
+	go runner.expire()
+
+	log.Printf("[OCR JOBS] Job runner started with %d workers, queue size %d, job timeout %v, job TTL %v", max(workers, 1), cap(runner.pending), timeout, ttl)
+	return runner
+}
+
+func (r *JobRunner) Submit(imageData []byte, params ImageExtractParams, callbackURL string) (*OCRJob, error) {
+	now := time.Now()
+	job := &OCRJob{
+		ID:          uuid.New().String(),
+		Status:      JobQueued,
+		FilterType:  params.FilterType,
+		CallbackURL: callbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		ExpiresAt:   now.Add(r.ttl),
+		imageData:   imageData,
+		params:      params,
+	}
+
+	if err := r.store.Create(job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case r.pending <- job.ID:
+		log.Printf("[OCR JOBS] Job %s queued, pending jobs: %d", job.ID, len(r.pending))
+		return job, nil
+	default:
+		// The client never learns the ID of a rejected job, so it must not
+		// stay in the store until it expires.
+		r.store.Delete(job.ID)
+		return nil, errJobQueueFull
+	}
+}
+
+func (r *JobRunner) Pending() int {
+	return len(r.pending)
+}
+
+func (r *JobRunner) work() {
+	for id := range r.pending {
+		job, err := r.store.Get(id)
+		if err != nil {
+			log.Printf("[OCR JOBS] Job %s disappeared before it could run: %v", id, err)
+			continue
+		}
+		r.run(job)
+	}
+}
+
+func (r *JobRunner) run(job *OCRJob) {
+	job.Status = JobRunning
+	job.UpdatedAt = time.Now()
+	r.store.Update(job)
+	log.Printf("[OCR JOBS] Job %s started", job.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	extraction, err := runImageExtraction(ctx, job.imageData, job.params)
+	job.imageData = nil
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = extractionErrorMessage(err)
+		job.Result = &OCRResponse{Success: false, Message: job.Error, ErrorCode: extractionErrorCode(err)}
+		log.Printf("[OCR JOBS] Job %s failed after %v: %v", job.ID, time.Since(startTime), err)
+	} else {
+		job.Status = JobSucceeded
+		job.Result = &OCRResponse{Success: true, TextList: extraction.Texts, TotalCount: len(extraction.Texts), Orientation: extraction.Orientation, Layout: extraction.Layout, MenuItems: extraction.MenuItems, Degraded: extraction.Degraded}
+		log.Printf("[OCR JOBS] Job %s succeeded in %v with %d text elements", job.ID, time.Since(startTime), len(extraction.Texts))
+	}
+	job.UpdatedAt = time.Now()
+	r.store.Update(job)
+
+	if job.CallbackURL != "" {
+		// Delivery runs on its own goroutine so a slow or dead callback
+		// endpoint does not hold up the job workers.
+		job.CallbackStatus = "pending"
+		r.store.Update(job)
+		go func() {
+			job.CallbackStatus = r.deliver(job)
+			job.UpdatedAt = time.Now()
+			r.store.Update(job)
+		}()
+	}
+}
+
+const callbackAttempts = 3
+
+// deliver posts the finished job to its callback URL, retrying a few times
+// with a growing delay before giving up.
+func (r *JobRunner) deliver(job *OCRJob) string {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("[OCR JOBS] Failed to encode callback payload for job %s: %v", job.ID, err)
+		return "failed"
+	}
+
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		resp, err := r.client.Post(job.CallbackURL, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				log.Printf("[OCR JOBS] Callback for job %s delivered to %s on attempt %d", job.ID, job.CallbackURL, attempt)
+				return "delivered"
+			}
+			err = fmt.Errorf("callback returned status %d", resp.StatusCode)
+		}
+		log.Printf("[OCR JOBS] Callback attempt %d for job %s failed: %v", attempt, job.ID, err)
+		if attempt < callbackAttempts {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+	}
+	return "failed"
+}
+
+func (r *JobRunner) expire() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, job := range r.store.DeleteExpired(now) {
+			log.Printf("[OCR JOBS] Job %s expired and was removed", job.ID)
+		}
+	}
+}
+
+var (
+	callbackHosts        []string
+	callbackAllowPrivate bool
+)
+
+func loadJobCallbackConfig() {
+	callbackHosts = parseHostList(os.Getenv("JOB_CALLBACK_ALLOWED_HOSTS"))
+	callbackAllowPrivate = getEnvBool("JOB_CALLBACK_ALLOW_PRIVATE", false)
+	log.Printf("[OCR JOBS CONFIG] Allowed callback hosts: %v, private addresses allowed: %t", callbackHosts, callbackAllowPrivate)
+}
+
+// validateCallbackURL rejects callbacks to hosts outside
+// JOB_CALLBACK_ALLOWED_HOSTS, when it is set, and to literal private
+// addresses. Host names are checked again when the callback client dials,
+// since they may resolve to a private address.
+func validateCallbackURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	host := parsed.Hostname()
+	if len(callbackHosts) > 0 && !matchesHostList(host, callbackHosts) {
+		return fmt.Errorf("callback host %s is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !callbackAllowPrivate && !isPublicIP(ip) {
+		return fmt.Errorf("callback host %s is not a public address", host)
+	}
+	return nil
+}
+
+// newCallbackClient refuses to connect to private, loopback and link-local
+// addresses unless JOB_CALLBACK_ALLOW_PRIVATE is set, so callbacks cannot
+// reach the metadata service or internal services. It does not use a proxy,
+// which would hide the address it connects to, or follow redirects.
+func newCallbackClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !callbackAllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("callback address %s is not a public address", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+var jobRunner *JobRunner
+
+func createImageJobHandler(c *gin.Context) {
+	clientIP := c.ClientIP()
+	log.Printf("[HTTP JOB REQUEST] Async OCR job request received from client IP: %s", clientIP)
+
+	params, err := parseImageExtractParams(c)
+	if err != nil {
+		log.Printf("[HTTP JOB REQUEST ERROR] Invalid request parameters: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "error_code": ErrorCodeInvalidParameter})
+		return
+	}
+
+	input, err := readImageInput(c)
+	if err != nil {
+		log.Printf("[HTTP JOB REQUEST ERROR] Failed to read image from request, client IP: %s, error: %v", clientIP, err)
+		c.JSON(inputErrorStatus(err), gin.H{"error": err.Error(), "error_code": inputErrorCode(err)})
+		return
+	}
+
+	callbackURL := input.CallbackURL
+	if callbackURL == "" {
+		callbackURL = c.Query("callback_url")
+	}
+	if callbackURL != "" {
+		if err := validateCallbackURL(callbackURL); err != nil {
+			log.Printf("[HTTP JOB REQUEST ERROR] Invalid callback URL %q: %v", callbackURL, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "error_code": ErrorCodeInvalidParameter})
+			return
+		}
+	}
+
+	job, err := jobRunner.Submit(input.Data, params, callbackURL)
+	if err != nil {
+		log.Printf("[HTTP JOB REQUEST ERROR] Job submission rejected for client IP: %s: %v", clientIP, err)
+		if err == errJobQueueFull {
+			c.Header("Retry-After", "10")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "error_code": ErrorCodeQueueFull})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("[HTTP JOB REQUEST SUCCESS] Job %s accepted for client IP: %s, filter type: '%s', callback: %t", job.ID, clientIP, params.FilterType, callbackURL != "")
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+func getJobHandler(c *gin.Context) {
+	id := c.Param("id")
+	job, err := jobRunner.store.Get(id)
+	if err != nil {
+		log.Printf("[HTTP JOB STATUS] Job %s not found for client IP: %s", id, c.ClientIP())
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("[HTTP JOB STATUS] Job %s status %s returned to client IP: %s", id, job.Status, c.ClientIP())
+	c.JSON(http.StatusOK, job)
+}
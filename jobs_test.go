@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobRunnerSubmitQueueFull(t *testing.T) {
+	store := NewMemoryJobStore()
+	// No workers, so the single queue slot stays taken.
+	runner := &JobRunner{store: store, pending: make(chan string, 1), ttl: time.Minute}
+
+	job, err := runner.Submit([]byte("image"), ImageExtractParams{}, "")
+	if err != nil {
+		t.Fatalf("first job rejected: %v", err)
+	}
+	if _, err := runner.Submit([]byte("image"), ImageExtractParams{}, ""); err != errJobQueueFull {
+		t.Fatalf("err = %v, want %v", err, errJobQueueFull)
+	}
+
+	if len(store.jobs) != 1 {
+		t.Errorf("store holds %d jobs, want only the queued one", len(store.jobs))
+	}
+	if stored, err := store.Get(job.ID); err != nil || stored.Status != JobQueued {
+		t.Errorf("queued job = %+v, %v", stored, err)
+	}
+}
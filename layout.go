@@ -0,0 +1,249 @@
+package main
+
+import (
+	"image"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	LayoutFlat       = "flat"
+	LayoutStructured = "structured"
+)
+
+type LayoutWord struct {
+	Text       string      `json:"text"`
+	BBox       BoundingBox `json:"bbox"`
+	Confidence float64     `json:"confidence,omitempty"`
+}
+
+type LayoutLine struct {
+	Text       string       `json:"text"`
+	BBox       BoundingBox  `json:"bbox"`
+	Confidence float64      `json:"confidence,omitempty"`
+	Words      []LayoutWord `json:"words"`
+}
+
+type LayoutBlock struct {
+	Text  string       `json:"text"`
+	BBox  BoundingBox  `json:"bbox"`
+	Lines []LayoutLine `json:"lines"`
+}
+
+// DocumentLayout is the text of an image as blocks of lines of words, in
+// reading order. Columns is the largest number of side-by-side columns found
+// anywhere on the page.
+type DocumentLayout struct {
+	Blocks  []LayoutBlock `json:"blocks"`
+	Columns int           `json:"columns"`
+}
+
+const (
+	// layoutLineGap is the largest gap between two elements of one line,
+	// relative to their height. Anything wider is treated as a column gap.
+	layoutLineGap = 1.5
+	// layoutBlockGap and layoutColumnGap are the smallest empty bands,
+	// relative to the median line height, that separate blocks and columns.
+	layoutBlockGap  = 0.8
+	layoutColumnGap = 1.0
+)
+
+type layoutLine struct {
+	box   image.Rectangle
+	words []LayoutWord
+	confs []float64
+}
+
+// buildDocumentLayout groups text elements into lines and then splits the
+// page recursively (XY-cut): first at vertical whitespace channels, which
+// separates columns, and otherwise at horizontal gaps, which separates
+// blocks. Reading the leaves depth first gives column-aware reading order.
+func buildDocumentLayout(elements []TextElement) *DocumentLayout {
+	lines := groupLayoutLines(elements)
+	layout := &DocumentLayout{Blocks: []LayoutBlock{}, Columns: 1}
+	if len(lines) == 0 {
+		return layout
+	}
+
+	heights := make([]int, len(lines))
+	for i, line := range lines {
+		heights[i] = line.box.Dy()
+	}
+	sort.Ints(heights)
+	lineHeight := float64(max(1, heights[len(heights)/2]))
+
+	var cut func(group []*layoutLine)
+	cut = func(group []*layoutLine) {
+		if columns := splitLayoutLines(group, false, layoutColumnGap*lineHeight); len(columns) > 1 {
+			layout.Columns = max(layout.Columns, len(columns))
+			for _, column := range columns {
+				cut(column)
+			}
+			return
+		}
+		if blocks := splitLayoutLines(group, true, layoutBlockGap*lineHeight); len(blocks) > 1 {
+			for _, block := range blocks {
+				cut(block)
+			}
+			return
+		}
+		layout.Blocks = append(layout.Blocks, newLayoutBlock(group))
+	}
+	cut(lines)
+
+	log.Printf("[OCR LAYOUT] Built layout from %d elements: %d lines, %d blocks, %d columns", len(elements), len(lines), len(layout.Blocks), layout.Columns)
+	return layout
+}
+
+// groupLayoutLines joins elements whose boxes overlap vertically and sit
+// close together horizontally into lines.
+func groupLayoutLines(elements []TextElement) []*layoutLine {
+	var sorted []TextElement
+	for _, elem := range elements {
+		if elem.BBox != nil && strings.TrimSpace(elem.Text) != "" {
+			sorted = append(sorted, elem)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].BBox.X < sorted[j].BBox.X })
+
+	var lines []*layoutLine
+	for _, elem := range sorted {
+		box := image.Rect(elem.BBox.X, elem.BBox.Y, elem.BBox.X+elem.BBox.Width, elem.BBox.Y+elem.BBox.Height)
+
+		var target *layoutLine
+		for _, line := range lines {
+			shorter := min(box.Dy(), line.box.Dy())
+			overlap := min(box.Max.Y, line.box.Max.Y) - max(box.Min.Y, line.box.Min.Y)
+			gap := box.Min.X - line.box.Max.X
+			if shorter > 0 && overlap*2 >= shorter && float64(gap) <= layoutLineGap*float64(max(box.Dy(), line.box.Dy())) {
+				target = line
+				break
+			}
+		}
+		if target == nil {
+			target = &layoutLine{box: box}
+			lines = append(lines, target)
+		}
+
+		target.box = target.box.Union(box)
+		target.words = append(target.words, elementWords(elem, box)...)
+		if elem.Confidence >= 0 {
+			target.confs = append(target.confs, elem.Confidence)
+		}
+	}
+	return lines
+}
+
+// elementWords returns the word boxes tesseract reported for an element, or,
+// when there are none or the text was corrected since, splits the element
+// box among its words in proportion to their length.
+func elementWords(elem TextElement, box image.Rectangle) []LayoutWord {
+	tokens := strings.Fields(elem.Text)
+	if len(elem.words) == len(tokens) {
+		matches := true
+		words := make([]LayoutWord, len(tokens))
+		for i, word := range elem.words {
+			if word.Text != tokens[i] {
+				matches = false
+				break
+			}
+			words[i] = LayoutWord{Text: word.Text, BBox: toBoundingBox(word.Box), Confidence: word.Confidence}
+		}
+		if matches {
+			return words
+		}
+	}
+
+	totalRunes := utf8.RuneCountInString(strings.Join(tokens, " "))
+	words := make([]LayoutWord, len(tokens))
+	offset := 0
+	for i, token := range tokens {
+		runes := utf8.RuneCountInString(token)
+		start := box.Min.X + box.Dx()*offset/max(1, totalRunes)
+		end := box.Min.X + box.Dx()*(offset+runes)/max(1, totalRunes)
+		words[i] = LayoutWord{Text: token, BBox: toBoundingBox(image.Rect(start, box.Min.Y, end, box.Max.Y)), Confidence: elem.Confidence}
+		offset += runes + 1
+	}
+	return words
+}
+
+// splitLayoutLines partitions lines at empty bands at least minGap wide,
+// along y when horizontal is set and along x otherwise. It returns the parts
+// top to bottom or left to right, or a single part when there is no gap.
+func splitLayoutLines(lines []*layoutLine, horizontal bool, minGap float64) [][]*layoutLine {
+	span := func(line *layoutLine) (int, int) {
+		if horizontal {
+			return line.box.Min.Y, line.box.Max.Y
+		}
+		return line.box.Min.X, line.box.Max.X
+	}
+
+	sorted := append([]*layoutLine(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := span(sorted[i])
+		b, _ := span(sorted[j])
+		return a < b
+	})
+
+	var parts [][]*layoutLine
+	var current []*layoutLine
+	reach := 0
+	for _, line := range sorted {
+		start, end := span(line)
+		if len(current) > 0 && float64(start-reach) >= minGap {
+			parts = append(parts, current)
+			current = nil
+		}
+		if len(current) == 0 || end > reach {
+			reach = end
+		}
+		current = append(current, line)
+	}
+	return append(parts, current)
+}
+
+func newLayoutBlock(lines []*layoutLine) LayoutBlock {
+	sorted := append([]*layoutLine(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].box.Min.Y+sorted[i].box.Dy()/2 < sorted[j].box.Min.Y+sorted[j].box.Dy()/2
+	})
+
+	block := LayoutBlock{Lines: make([]LayoutLine, len(sorted))}
+	var box image.Rectangle
+	texts := make([]string, len(sorted))
+	for i, line := range sorted {
+		words := append([]LayoutWord(nil), line.words...)
+		sort.SliceStable(words, func(a, b int) bool { return words[a].BBox.X < words[b].BBox.X })
+
+		tokens := make([]string, len(words))
+		for j, word := range words {
+			tokens[j] = word.Text
+		}
+
+		var confidence float64
+		for _, conf := range line.confs {
+			confidence += conf
+		}
+		if len(line.confs) > 0 {
+			confidence /= float64(len(line.confs))
+		}
+
+		texts[i] = strings.Join(tokens, " ")
+		block.Lines[i] = LayoutLine{Text: texts[i], BBox: toBoundingBox(line.box), Confidence: confidence, Words: words}
+		if i == 0 {
+			box = line.box
+		} else {
+			box = box.Union(line.box)
+		}
+	}
+
+	block.Text = strings.Join(texts, "\n")
+	block.BBox = toBoundingBox(box)
+	return block
+}
+
+func toBoundingBox(r image.Rectangle) BoundingBox {
+	return BoundingBox{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+}
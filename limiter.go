@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gocv.io/x/gocv"
+)
+
+// LimitedOCREngine caps how many recognitions run at once across the whole
+// server, no matter how many requests or region workers are asking.
+type LimitedOCREngine struct {
+	engine OCREngine
+	slots  chan struct{}
+	busy   atomic.Int64
+}
+
+func NewLimitedOCREngine(engine OCREngine, maxConcurrent int) *LimitedOCREngine {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &LimitedOCREngine{engine: engine, slots: make(chan struct{}, maxConcurrent)}
+}
+
+func (l *LimitedOCREngine) Name() string {
+	return l.engine.Name()
+}
+
+func (l *LimitedOCREngine) Recognize(ctx context.Context, img gocv.Mat, opts OCROptions) (OCRResult, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return OCRResult{Confidence: -1}, ctx.Err()
+	}
+	l.busy.Add(1)
+	defer func() {
+		l.busy.Add(-1)
+		<-l.slots
+	}()
+
+	return l.engine.Recognize(ctx, img, opts)
+}
+
+// DetectOrientation takes a slot like Recognize, since orientation detection
+// also runs a tesseract process.
+func (l *LimitedOCREngine) DetectOrientation(ctx context.Context, img gocv.Mat) (OrientationResult, error) {
+	detector, ok := l.engine.(OrientationDetector)
+	if !ok {
+		return OrientationResult{}, errOrientationUnsupported
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return OrientationResult{}, ctx.Err()
+	}
+	l.busy.Add(1)
+	defer func() {
+		l.busy.Add(-1)
+		<-l.slots
+	}()
+
+	return detector.DetectOrientation(ctx, img)
+}
+
+func (l *LimitedOCREngine) Stats() map[string]interface{} {
+	return map[string]interface{}{"busy": l.busy.Load(), "max": cap(l.slots)}
+}
+
+var errQueueFull = fmt.Errorf("OCR request queue is full")
+var errQueueTimeout = fmt.Errorf("timed out waiting in OCR request queue")
+
+// AdmissionQueue admits at most maxActive OCR requests at a time and lets up
+// to queueSize more wait for a slot. Anything beyond that is rejected
+// immediately so a burst cannot pile up unbounded work.
+type AdmissionQueue struct {
+	active      chan struct{}
+	queueSize   int
+	waitTimeout time.Duration
+
+	mu            sync.Mutex
+	queued        int
+	rejected      int64
+	timedOut      int64
+	admitted      int64
+	totalWait     time.Duration
+	maxWait       time.Duration
+	totalDuration time.Duration
+	completed     int64
+}
+
+func NewAdmissionQueue(maxActive, queueSize int, waitTimeout time.Duration) *AdmissionQueue {
+	if maxActive < 1 {
+		maxActive = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &AdmissionQueue{active: make(chan struct{}, maxActive), queueSize: queueSize, waitTimeout: waitTimeout}
+}
+
+// Acquire blocks until the request may run or ctx is done. The returned
+// release function must be called once the request is done.
+func (q *AdmissionQueue) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case q.active <- struct{}{}:
+		return q.admit(0), nil
+	default:
+	}
+
+	q.mu.Lock()
+	if q.queued >= q.queueSize {
+		q.rejected++
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+	q.queued++
+	q.mu.Unlock()
+
+	waitStart := time.Now()
+	timer := time.NewTimer(q.waitTimeout)
+	defer timer.Stop()
+
+	select {
+	case q.active <- struct{}{}:
+		q.mu.Lock()
+		q.queued--
+		q.mu.Unlock()
+		return q.admit(time.Since(waitStart)), nil
+	case <-timer.C:
+		q.mu.Lock()
+		q.queued--
+		q.timedOut++
+		q.mu.Unlock()
+		return nil, errQueueTimeout
+	case <-ctx.Done():
+		q.mu.Lock()
+		q.queued--
+		q.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (q *AdmissionQueue) admit(wait time.Duration) func() {
+	q.mu.Lock()
+	q.admitted++
+	q.totalWait += wait
+	if wait > q.maxWait {
+		q.maxWait = wait
+	}
+	q.mu.Unlock()
+
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			q.completed++
+			q.totalDuration += time.Since(start)
+			q.mu.Unlock()
+			<-q.active
+		})
+	}
+}
+
+// MaxActive is the number of requests that may run at once.
+func (q *AdmissionQueue) MaxActive() int {
+	return cap(q.active)
+}
+
+// RetryAfter estimates in whole seconds how long until a queued request would
+// be admitted, based on the average time requests have been taking.
+func (q *AdmissionQueue) RetryAfter() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	average := 5 * time.Second
+	if q.completed > 0 {
+		average = q.totalDuration / time.Duration(q.completed)
+	}
+	waves := float64(q.queued+1) / float64(cap(q.active))
+	return max(1, int(math.Ceil(waves*average.Seconds())))
+}
+
+func (q *AdmissionQueue) Stats() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var averageWait time.Duration
+	if q.admitted > 0 {
+		averageWait = q.totalWait / time.Duration(q.admitted)
+	}
+	return map[string]interface{}{
+		"active":         len(q.active),
+		"max_active":     cap(q.active),
+		"queued":         q.queued,
+		"queue_capacity": q.queueSize,
+		"admitted":       q.admitted,
+		"rejected":       q.rejected,
+		"timed_out":      q.timedOut,
+		"avg_wait_ms":    averageWait.Milliseconds(),
+		"max_wait_ms":    q.maxWait.Milliseconds(),
+	}
+}
+
+func admissionMiddleware(queue *AdmissionQueue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, err := queue.Acquire(c.Request.Context())
+		if err != nil {
+			retryAfter := queue.RetryAfter()
+			status := http.StatusServiceUnavailable
+			if err == errQueueFull {
+				status = http.StatusTooManyRequests
+			}
+			if c.Request.Context().Err() != nil {
+				log.Printf("[HTTP ADMISSION] Client IP: %s disconnected while waiting in queue", c.ClientIP())
+				c.Abort()
+				return
+			}
+			log.Printf("[HTTP ADMISSION] Rejecting request from client IP: %s with status %d: %v, retry after %ds", c.ClientIP(), status, err, retryAfter)
+			c.Header("Retry-After", fmt.Sprintf("%d", retryAfter))
+			c.AbortWithStatusJSON(status, OCRResponse{Success: false, Message: extractionErrorMessage(err), ErrorCode: extractionErrorCode(err)})
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
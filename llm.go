@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type LLMTask string
+
+const (
+	TaskExtractStore  LLMTask = "extract_store"
+	TaskExtractNumber LLMTask = "extract_number"
+	TaskExtractFood   LLMTask = "extract_food"
+	TaskFilterStores  LLMTask = "filter_stores"
+	TaskFilterFoods   LLMTask = "filter_foods"
+)
+
+const llmSystemPrompt = "You are a precise OCR text analysis specialist with expertise in Korean text recognition errors. Follow instructions exactly. Return only the requested information without explanations, formatting, or additional text. Handle OCR recognition errors intelligently."
+
+// LLMRequest carries the rendered prompt together with the raw task input so
+// that providers which do not talk to a model can still answer it.
+type LLMRequest struct {
+	Task   LLMTask
+	Prompt string
+	Input  string
+	Items  []string
+	// Schema, when set, asks for a JSON reply that matches it.
+	Schema *ResponseSchema
+	// MaxTokens overrides the provider's reply length limit when set.
+	MaxTokens int
+	// Validate, when set, checks a reply the way its caller will read it.
+	// Replies it rejects are not cached.
+	Validate func(reply string) error
+}
+
+// ResponseSchema is a JSON schema the reply must follow, sent to the model
+// as a structured output format.
+type ResponseSchema struct {
+	Name   string
+	Schema map[string]any
+}
+
+type LLMProvider interface {
+	Name() string
+	Complete(ctx context.Context, req LLMRequest) (string, error)
+}
+
+type OpenAIProvider struct {
+	name        string
+	baseURL     string
+	apiKey      string
+	model       string
+	temperature float64
+	maxTokens   int
+	requireKey  bool
+	client      *http.Client
+}
+
+func NewOpenAIProvider(apiKey, model string) *OpenAIProvider {
+	return &OpenAIProvider{
+		name:        "openai",
+		baseURL:     "https://api.openai.com/v1",
+		apiKey:      apiKey,
+		model:       model,
+		temperature: 0.1,
+		maxTokens:   150,
+		requireKey:  true,
+		client:      llmHTTPClient,
+	}
+}
+
+// llmHTTPClient is shared by every provider so connections to the API are
+// pooled and kept alive between requests.
+var llmHTTPClient = &http.Client{Timeout: 30 * time.Second, Transport: newLLMTransport()}
+
+func newLLMTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 32
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// LLMRetryConfig controls how failed API calls are retried. Delays grow
+// exponentially from BaseDelay up to MaxDelay with full jitter, unless the
+// API asks for a longer wait with Retry-After.
+type LLMRetryConfig struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+var llmRetry = LLMRetryConfig{MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+func loadLLMRetryConfig() {
+	llmRetry.MaxRetries = max(0, getEnvInt("LLM_MAX_RETRIES", llmRetry.MaxRetries))
+	llmRetry.BaseDelay = time.Duration(getEnvInt("LLM_RETRY_BASE_MS", int(llmRetry.BaseDelay/time.Millisecond))) * time.Millisecond
+	llmRetry.MaxDelay = time.Duration(getEnvInt("LLM_RETRY_MAX_MS", int(llmRetry.MaxDelay/time.Millisecond))) * time.Millisecond
+	log.Printf("[LLM CONFIG] Max retries: %d, base delay: %v, max delay: %v", llmRetry.MaxRetries, llmRetry.BaseDelay, llmRetry.MaxDelay)
+}
+
+// retryDelay is the wait before retry number attempt.
+func (cfg LLMRetryConfig) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	backoff := cfg.BaseDelay
+	for i := 1; i < attempt && backoff < cfg.MaxDelay; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, cfg.MaxDelay)
+
+	delay := time.Duration(0)
+	if backoff > 0 {
+		delay = rand.N(backoff + 1)
+	}
+	return max(delay, retryAfter)
+}
+
+// maxLLMResponseBytes bounds how much of a reply is read, since the body is
+// held in memory to be parsed either as a completion or an error.
+const maxLLMResponseBytes = 4 << 20
+
+// NewOpenAICompatibleProvider targets any server exposing the OpenAI chat
+// completions API (vLLM, Ollama, llama.cpp server). The API key is optional.
+func NewOpenAICompatibleProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	provider := NewOpenAIProvider(apiKey, model)
+	provider.name = "openai-compatible"
+	provider.baseURL = strings.TrimRight(baseURL, "/")
+	provider.requireKey = false
+	return provider
+}
+
+func (p *OpenAIProvider) Name() string {
+	return p.name
+}
+
+func (p *OpenAIProvider) Model() string {
+	return p.model
+}
+
+// llmModel names the model behind a provider. Providers that do not run a
+// model are named after themselves.
+func llmModel(provider LLMProvider) string {
+	if withModel, ok := provider.(interface{ Model() string }); ok {
+		return withModel.Model()
+	}
+	return provider.Name()
+}
+
+func (p *OpenAIProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	if p.requireKey && p.apiKey == "" {
+		return "", &LLMError{Kind: LLMErrorUnavailable, Provider: p.name, Message: "OPENAI_API_KEY environment variable not set", Err: errLLMNotConfigured}
+	}
+
+	requestBody := OpenAIRequest{
+		Model:       p.model,
+		Temperature: p.temperature,
+		MaxTokens:   p.maxTokens,
+		Messages: []Message{
+			{Role: "system", Content: llmSystemPrompt},
+			{Role: "user", Content: req.Prompt},
+		},
+	}
+	if req.MaxTokens > 0 {
+		requestBody.MaxTokens = req.MaxTokens
+	}
+	if req.Schema != nil {
+		requestBody.ResponseFormat = &ResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &JSONSchemaFormat{Name: req.Schema.Name, Strict: true, Schema: req.Schema.Schema},
+		}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 1; ; attempt++ {
+		content, err := p.send(ctx, jsonData)
+		if err == nil {
+			return content, nil
+		}
+
+		var llmErr *LLMError
+		if !errors.As(err, &llmErr) {
+			return "", err
+		}
+		llmErr.Attempts = attempt
+		if !llmErr.retryable() || attempt > llmRetry.MaxRetries {
+			return "", llmErr
+		}
+
+		delay := llmRetry.retryDelay(attempt, llmErr.RetryAfter)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			log.Printf("[LLM RETRY] %s attempt %d failed and the request deadline is sooner than the %v retry delay: %v", p.name, attempt, delay, llmErr)
+			return "", llmErr
+		}
+		log.Printf("[LLM RETRY] %s attempt %d failed, retrying in %v: %v", p.name, attempt, delay, llmErr)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes one chat completion call. Context errors are returned as they
+// are so callers can tell a cancelled request from a failing API.
+func (p *OpenAIProvider) send(ctx context.Context, body []byte) (string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", &LLMError{Kind: LLMErrorUnavailable, Provider: p.name, Message: "request failed", Err: err}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLLMResponseBytes))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", &LLMError{Kind: LLMErrorUnavailable, Provider: p.name, Message: "failed to read response", Err: err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Bodies that are not an API error object, such as proxy error
+		// pages, leave the message as the status text.
+		var errorBody apiErrorBody
+		json.Unmarshal(data, &errorBody)
+		return "", newLLMStatusError(p.name, resp, errorBody)
+	}
+
+	var openAIResp OpenAIResponse
+	if err := json.Unmarshal(data, &openAIResp); err != nil {
+		return "", &LLMError{Kind: LLMErrorBadResponse, Provider: p.name, Message: "invalid response body", Err: err}
+	}
+
+	if len(openAIResp.Choices) == 0 {
+		return "", &LLMError{Kind: LLMErrorBadResponse, Provider: p.name, Message: "no choices in response"}
+	}
+
+	return strings.TrimSpace(openAIResp.Choices[0].Message.Content), nil
+}
+
+// RuleBasedProvider answers every task deterministically without a model.
+// Answers found in the fixture file take precedence over the built-in rules,
+// which makes it usable in CI and offline development. A fixture answer is
+// either a string, returned as is, or a JSON value, returned as its JSON
+// text for tasks that expect structured replies.
+type RuleBasedProvider struct {
+	fixtures map[LLMTask]map[string]json.RawMessage
+}
+
+func NewRuleBasedProvider(fixturePath string) (*RuleBasedProvider, error) {
+	provider := &RuleBasedProvider{fixtures: map[LLMTask]map[string]json.RawMessage{}}
+	if fixturePath == "" {
+		return provider, nil
+	}
+
+	data, err := os.ReadFile(fixturePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM fixture file: %w", err)
+	}
+	if err := json.Unmarshal(data, &provider.fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM fixture file: %w", err)
+	}
+
+	log.Printf("[LLM STUB] Loaded fixtures for %d tasks from %s", len(provider.fixtures), fixturePath)
+	return provider, nil
+}
+
+func (p *RuleBasedProvider) Name() string {
+	return "stub"
+}
+
+func (p *RuleBasedProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	key := req.Input
+	if len(req.Items) > 0 {
+		key = strings.Join(req.Items, "\n")
+	}
+	if answer, ok := p.fixtures[req.Task][key]; ok {
+		log.Printf("[LLM STUB] Fixture hit for task %s", req.Task)
+		var text string
+		if err := json.Unmarshal(answer, &text); err == nil {
+			return text, nil
+		}
+		return string(answer), nil
+	}
+
+	switch req.Task {
+	case TaskExtractNumber:
+		return stubExtractNumber(req.Input), nil
+	case TaskExtractStore, TaskExtractFood:
+		return stubExtractName(req.Input), nil
+	case TaskFilterStores:
+		return stubFilterReply(req.Items, 1)
+	case TaskFilterFoods:
+		return stubFilterReply(req.Items, 0)
+	}
+
+	return "", fmt.Errorf("stub provider does not support task %q", req.Task)
+}
+
+var stubFillerWords = map[string]bool{
+	"어": true, "아": true, "그": true, "음": true, "잠깐만": true, "뭐지": true, "그냥": true, "어어": true,
+}
+
+var stubPricePattern = regexp.MustCompile(`\d[\d,.]*\s*(원|₩)|₩\s*\d|^\d[\d,.\-]*$`)
+
+func stubExtractNumber(text string) string {
+	counts := make(map[string]int)
+	var order []string
+	for _, number := range regexp.MustCompile(`\d+`).FindAllString(text, -1) {
+		if counts[number] == 0 {
+			order = append(order, number)
+		}
+		counts[number]++
+	}
+
+	best := ""
+	for _, number := range order {
+		if best == "" || counts[number] > counts[best] {
+			best = number
+		}
+	}
+	if best == "" {
+		return "NONE"
+	}
+	return best
+}
+
+// stubExtractName picks the word that most other words start with, which is
+// how a repeated name usually shows up in stuttered speech.
+func stubExtractName(text string) string {
+	var words []string
+	for _, word := range strings.Fields(text) {
+		word = strings.Trim(word, ".,!?\"'")
+		if word != "" && !stubFillerWords[word] {
+			words = append(words, word)
+		}
+	}
+
+	best, bestScore := "", 0
+	for _, candidate := range words {
+		score := 0
+		for _, word := range words {
+			if strings.HasPrefix(word, candidate) {
+				score++
+			}
+		}
+		if score > bestScore || (score == bestScore && utf8.RuneCountInString(candidate) > utf8.RuneCountInString(best)) {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if best == "" || bestScore < 2 && len(words) > 2 {
+		return "NONE"
+	}
+	return best
+}
+
+// stubFilterReply keeps up to limit items (all of them when limit is 0)
+// that do not look like prices, as a filter reply that leaves the text
+// uncorrected.
+func stubFilterReply(items []string, limit int) (string, error) {
+	reply := filterReply{Items: []filterReplyItem{}}
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || stubPricePattern.MatchString(item) {
+			continue
+		}
+		reply.Items = append(reply.Items, filterReplyItem{OriginalIndex: i, CorrectedText: item, Confidence: 1})
+		if len(reply.Items) == limit {
+			break
+		}
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func NewLLMProviderFromEnv() (LLMProvider, error) {
+	providerName := os.Getenv("LLM_PROVIDER")
+	model := os.Getenv("LLM_MODEL")
+	if model == "" {
+		model = "gpt-4o-mini"
+	}
+	apiKey := os.Getenv("LLM_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+
+	switch providerName {
+	case "", "openai":
+		return NewOpenAIProvider(apiKey, model), nil
+	case "openai-compatible":
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL is required for the openai-compatible provider")
+		}
+		return NewOpenAICompatibleProvider(baseURL, apiKey, model), nil
+	case "stub":
+		return NewRuleBasedProvider(os.Getenv("LLM_FIXTURE_FILE"))
+	}
+
+	return nil, fmt.Errorf("unknown LLM provider %q", providerName)
+}
+
+var llmProvider LLMProvider
+
+func callLLM(ctx context.Context, req LLMRequest) (string, error) {
+	if llmProvider == nil {
+		return "", fmt.Errorf("LLM provider not configured")
+	}
+
+	startTime := time.Now()
+	result, err := llmProvider.Complete(ctx, req)
+	if err != nil {
+		log.Printf("[LLM CALL ERROR] Provider %s failed task %s after %v: %v", llmProvider.Name(), req.Task, time.Since(startTime), err)
+		return "", err
+	}
+
+	log.Printf("[LLM CALL] Provider %s completed task %s in %v, result length: %d characters", llmProvider.Name(), req.Task, time.Since(startTime), len(result))
+	return result, nil
+}
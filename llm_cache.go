@@ -0,0 +1,299 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LLMCache stores model replies by cache key. Implementations must be safe
+// for concurrent use and drop entries once they expire.
+type LLMCache interface {
+	Get(key string) (string, bool)
+	Set(key, value string)
+	Len() int
+}
+
+// llmCacheVersion is part of every key. Bump it when prompts change so
+// replies to the old prompts are not served from a file cache.
+const llmCacheVersion = 1
+
+// llmCacheKey identifies a request by task, model and input. Inputs are
+// compared with surrounding and repeated whitespace removed; filter items
+// keep their order since replies refer to items by index.
+func llmCacheKey(model string, req LLMRequest) string {
+	normalize := func(text string) string {
+		return strings.Join(strings.Fields(text), " ")
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "v%d\x00%s\x00%s\x00%s", llmCacheVersion, req.Task, model, normalize(req.Input))
+	for _, item := range req.Items {
+		fmt.Fprintf(hash, "\x00%s", normalize(item))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryLLMCache is an LRU cache holding at most capacity replies for ttl
+// each.
+type MemoryLLMCache struct {
+	capacity int
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List
+	evictions int64
+}
+
+func NewMemoryLLMCache(capacity int, ttl time.Duration) *MemoryLLMCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryLLMCache{capacity: capacity, ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *MemoryLLMCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *MemoryLLMCache) Set(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*memoryCacheEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+		c.evictions++
+	}
+}
+
+func (c *MemoryLLMCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *MemoryLLMCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+// FileLLMCache keeps one JSON file per reply in dir, so cached replies
+// survive restarts and can be shared by instances on the same volume.
+type FileLLMCache struct {
+	dir string
+	ttl time.Duration
+}
+
+type fileCacheEntry struct {
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewFileLLMCache creates dir if needed and removes replies that expired
+// while the server was down.
+func NewFileLLMCache(dir string, ttl time.Duration) (*FileLLMCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create LLM cache directory: %w", err)
+	}
+	cache := &FileLLMCache{dir: dir, ttl: ttl}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	removed := 0
+	for _, path := range paths {
+		if _, ok := cache.read(path); !ok {
+			removed++
+		}
+	}
+	log.Printf("[LLM CACHE] File cache in %s has %d replies, removed %d expired", dir, len(paths)-removed, removed)
+	return cache, nil
+}
+
+func (c *FileLLMCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// read returns the reply stored at path, removing the file when it has
+// expired or cannot be parsed.
+func (c *FileLLMCache) read(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	var entry fileCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Now().After(entry.ExpiresAt) {
+		os.Remove(path)
+		return "", false
+	}
+	return entry.Value, true
+}
+
+func (c *FileLLMCache) Get(key string) (string, bool) {
+	return c.read(c.path(key))
+}
+
+// Set writes through a temporary file so readers never see a partial reply.
+func (c *FileLLMCache) Set(key, value string) {
+	data, err := json.Marshal(fileCacheEntry{Value: value, ExpiresAt: time.Now().Add(c.ttl)})
+	if err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("[LLM CACHE ERROR] Failed to write cache entry: %v", err)
+		return
+	}
+	_, writeErr := tmp.Write(data)
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[LLM CACHE ERROR] Failed to write cache entry: %v", errors.Join(writeErr, closeErr))
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[LLM CACHE ERROR] Failed to store cache entry: %v", err)
+	}
+}
+
+func (c *FileLLMCache) Len() int {
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return 0
+	}
+	return len(paths)
+}
+
+// CachedLLMProvider answers repeated requests from cache. It sits outside
+// the circuit breaker so cached replies are still served while the API is
+// down. Failed calls are never cached, and neither are replies the
+// request's Validate function rejects.
+type CachedLLMProvider struct {
+	provider LLMProvider
+	cache    LLMCache
+	model    string
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func NewCachedLLMProvider(provider LLMProvider, cache LLMCache, model string) *CachedLLMProvider {
+	return &CachedLLMProvider{provider: provider, cache: cache, model: model}
+}
+
+func (p *CachedLLMProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *CachedLLMProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	key := llmCacheKey(p.model, req)
+	if value, ok := p.cache.Get(key); ok {
+		p.hits.Add(1)
+		log.Printf("[LLM CACHE] Hit for task %s", req.Task)
+		return value, nil
+	}
+	p.misses.Add(1)
+
+	result, err := p.provider.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if req.Validate != nil {
+		if err := req.Validate(result); err != nil {
+			log.Printf("[LLM CACHE] Not caching reply for task %s: %v", req.Task, err)
+			return result, nil
+		}
+	}
+	p.cache.Set(key, result)
+	return result, nil
+}
+
+func (p *CachedLLMProvider) Stats() map[string]interface{} {
+	hits, misses := p.hits.Load(), p.misses.Load()
+	stats := map[string]interface{}{"hits": hits, "misses": misses, "entries": p.cache.Len(), "hit_rate": 0.0}
+	if hits+misses > 0 {
+		stats["hit_rate"] = float64(hits) / float64(hits+misses)
+	}
+	if memory, ok := p.cache.(*MemoryLLMCache); ok {
+		stats["evictions"] = memory.Evictions()
+	}
+	return stats
+}
+
+var llmResponseCache *CachedLLMProvider
+
+// NewLLMCacheFromEnv builds the cache selected by LLM_CACHE: "memory" (the
+// default), "file" or "off", which returns nil.
+func NewLLMCacheFromEnv() (LLMCache, error) {
+	ttl := time.Duration(getEnvInt("LLM_CACHE_TTL_MINUTES", 24*60)) * time.Minute
+
+	switch kind := os.Getenv("LLM_CACHE"); kind {
+	case "", "memory":
+		size := getEnvInt("LLM_CACHE_SIZE", 1000)
+		log.Printf("[LLM CACHE CONFIG] Memory cache, size: %d, TTL: %v", size, ttl)
+		return NewMemoryLLMCache(size, ttl), nil
+	case "file":
+		dir := os.Getenv("LLM_CACHE_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("LLM_CACHE_DIR is required for the file cache")
+		}
+		log.Printf("[LLM CACHE CONFIG] File cache in %s, TTL: %v", dir, ttl)
+		cache, err := NewFileLLMCache(dir, ttl)
+		if err != nil {
+			return nil, err
+		}
+		return cache, nil
+	case "off":
+		log.Printf("[LLM CACHE CONFIG] Cache disabled")
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM cache %q", kind)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryLLMCache(t *testing.T) {
+	t.Run("evicts the least recently used reply", func(t *testing.T) {
+		cache := NewMemoryLLMCache(2, time.Hour)
+		cache.Set("a", "1")
+		cache.Set("b", "2")
+		if _, ok := cache.Get("a"); !ok {
+			t.Fatal("missing a")
+		}
+		cache.Set("c", "3")
+
+		if _, ok := cache.Get("b"); ok {
+			t.Error("b was not evicted")
+		}
+		for key, want := range map[string]string{"a": "1", "c": "3"} {
+			if got, ok := cache.Get(key); !ok || got != want {
+				t.Errorf("Get(%q) = %q, %t, want %q", key, got, ok, want)
+			}
+		}
+		if cache.Len() != 2 || cache.Evictions() != 1 {
+			t.Errorf("len %d, evictions %d, want 2 and 1", cache.Len(), cache.Evictions())
+		}
+	})
+
+	t.Run("overwrites without evicting", func(t *testing.T) {
+		cache := NewMemoryLLMCache(2, time.Hour)
+		cache.Set("a", "1")
+		cache.Set("b", "2")
+		cache.Set("a", "3")
+		if got, _ := cache.Get("a"); got != "3" || cache.Len() != 2 || cache.Evictions() != 0 {
+			t.Errorf("Get(a) = %q, len %d, evictions %d", got, cache.Len(), cache.Evictions())
+		}
+	})
+
+	t.Run("drops expired replies", func(t *testing.T) {
+		cache := NewMemoryLLMCache(2, -time.Second)
+		cache.Set("a", "1")
+		if _, ok := cache.Get("a"); ok {
+			t.Error("expired reply returned")
+		}
+		if cache.Len() != 0 {
+			t.Errorf("len = %d, want 0", cache.Len())
+		}
+	})
+}
+
+func TestLLMCacheKey(t *testing.T) {
+	base := LLMRequest{Task: TaskFilterFoods, Items: []string{"빅맥세트", "콜라"}}
+	tests := []struct {
+		name  string
+		model string
+		req   LLMRequest
+		same  bool
+	}{
+		{name: "whitespace ignored", model: "m", req: LLMRequest{Task: TaskFilterFoods, Items: []string{" 빅맥세트", "콜라  "}}, same: true},
+		{name: "other model", model: "n", req: base},
+		{name: "other task", model: "m", req: LLMRequest{Task: TaskFilterStores, Items: base.Items}},
+		{name: "items reordered", model: "m", req: LLMRequest{Task: TaskFilterFoods, Items: []string{"콜라", "빅맥세트"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := llmCacheKey("m", base) == llmCacheKey(tt.model, tt.req); same != tt.same {
+				t.Errorf("same key = %t, want %t", same, tt.same)
+			}
+		})
+	}
+}
+
+type countingLLMProvider struct {
+	reply string
+	calls int
+}
+
+func (p *countingLLMProvider) Name() string {
+	return "counting"
+}
+
+func (p *countingLLMProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	p.calls++
+	return p.reply, nil
+}
+
+func TestCachedLLMProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     string
+		validate  func(string) error
+		wantCalls int
+	}{
+		{name: "reply cached", reply: `{"items": []}`, validate: validateFilterReply, wantCalls: 1},
+		{name: "no validation", reply: "anything", wantCalls: 1},
+		{name: "rejected reply not cached", reply: `{"names": []}`, validate: validateFilterReply, wantCalls: 2},
+		{name: "failing validation", reply: "ok", validate: func(string) error { return errors.New("bad") }, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &countingLLMProvider{reply: tt.reply}
+			provider := NewCachedLLMProvider(base, NewMemoryLLMCache(10, time.Hour), "m")
+			req := LLMRequest{Task: TaskFilterFoods, Items: []string{"콜라"}, Validate: tt.validate}
+			for i := 0; i < 2; i++ {
+				reply, err := provider.Complete(context.Background(), req)
+				if err != nil || reply != tt.reply {
+					t.Fatalf("Complete = %q, %v, want %q", reply, err, tt.reply)
+				}
+			}
+			if base.calls != tt.wantCalls {
+				t.Errorf("provider called %d times, want %d", base.calls, tt.wantCalls)
+			}
+		})
+	}
+}
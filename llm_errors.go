@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LLMErrorKind sorts failed model calls by how the client should be
+// answered.
+type LLMErrorKind string
+
+const (
+	// LLMErrorRateLimited means the API kept rejecting us for sending too
+	// many requests, even after waiting as told.
+	LLMErrorRateLimited LLMErrorKind = "llm_rate_limited"
+	// LLMErrorUnavailable means the API could not be reached, kept failing
+	// or is not configured.
+	LLMErrorUnavailable LLMErrorKind = "llm_unavailable"
+	// LLMErrorBadResponse means the API rejected the request or answered
+	// with something that is not a usable completion.
+	LLMErrorBadResponse LLMErrorKind = "llm_bad_response"
+)
+
+// errLLMNotConfigured marks an unavailable LLM that is missing its
+// configuration, which no fallback should hide.
+var errLLMNotConfigured = errors.New("LLM provider is not configured")
+
+// LLMError is a model call that failed. StatusCode, Type and Code come from
+// the API response when there was one; RetryAfter is what the API asked us
+// to wait before the last attempt gave up.
+type LLMError struct {
+	Kind       LLMErrorKind
+	Provider   string
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+	Attempts   int
+	Err        error
+}
+
+func (e *LLMError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", e.Provider, e.Message)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (status %d", e.StatusCode)
+		if e.Code != "" {
+			fmt.Fprintf(&b, ", code %s", e.Code)
+		}
+		b.WriteString(")")
+	}
+	if e.Attempts > 1 {
+		fmt.Fprintf(&b, " after %d attempts", e.Attempts)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus is the status a handler answers with when this error stops a
+// request.
+func (e *LLMError) HTTPStatus() int {
+	switch e.Kind {
+	case LLMErrorRateLimited:
+		return http.StatusTooManyRequests
+	case LLMErrorUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// apiErrorBody is the error object OpenAI and most compatible servers send
+// with a failed request. Some servers send a numeric code, so Code is left
+// untyped.
+type apiErrorBody struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    any    `json:"code"`
+	} `json:"error"`
+}
+
+// newLLMStatusError classifies a non-2xx API response.
+func newLLMStatusError(provider string, resp *http.Response, body apiErrorBody) *LLMError {
+	llmErr := &LLMError{
+		Kind:       LLMErrorBadResponse,
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Type:       body.Error.Type,
+		Message:    body.Error.Message,
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+	}
+	if body.Error.Code != nil {
+		llmErr.Code = fmt.Sprint(body.Error.Code)
+	}
+	if llmErr.Message == "" {
+		llmErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests && (llmErr.Code == "insufficient_quota" || llmErr.Type == "insufficient_quota"):
+		// An exhausted quota will not recover by waiting.
+		llmErr.Kind = LLMErrorUnavailable
+	case resp.StatusCode == http.StatusTooManyRequests:
+		llmErr.Kind = LLMErrorRateLimited
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		llmErr.Kind = LLMErrorUnavailable
+	}
+	return llmErr
+}
+
+// retryable reports whether another attempt may succeed.
+func (e *LLMError) retryable() bool {
+	switch {
+	case e.Kind == LLMErrorRateLimited:
+		return true
+	case e.Kind == LLMErrorUnavailable && e.StatusCode == 0:
+		// Network failures; a missing API key never gets here as it is
+		// reported before the first attempt.
+		return true
+	case e.Kind == LLMErrorUnavailable:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500
+	}
+	return false
+}
+
+// parseRetryAfter reads the wait the API asked for, preferring OpenAI's
+// millisecond header over the standard one in seconds or as an HTTP date.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if value := header.Get("Retry-After-Ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(0, time.Duration(seconds*float64(time.Second)))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(0, at.Sub(now))
+	}
+	return 0
+}
+
+func llmErrorStatus(err error) (int, bool) {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return llmErr.HTTPStatus(), true
+	}
+	return 0, false
+}
+
+func llmErrorCode(err error) string {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return string(llmErr.Kind)
+	}
+	return ""
+}
+
+// retryAfterSeconds is the Retry-After header value to send with a rate
+// limited response, or "" for any other error.
+func retryAfterSeconds(err error) string {
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) || llmErr.Kind != LLMErrorRateLimited {
+		return ""
+	}
+	return strconv.Itoa(max(1, int((llmErr.RetryAfter+time.Second-1)/time.Second)))
+}
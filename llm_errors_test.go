@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "none", header: http.Header{}, want: 0},
+		{name: "seconds", header: http.Header{"Retry-After": {"3"}}, want: 3 * time.Second},
+		{name: "fractional seconds", header: http.Header{"Retry-After": {"1.5"}}, want: 1500 * time.Millisecond},
+		{name: "negative seconds", header: http.Header{"Retry-After": {"-2"}}, want: 0},
+		{name: "http date", header: http.Header{"Retry-After": {now.Add(7 * time.Second).Format(http.TimeFormat)}}, want: 7 * time.Second},
+		{name: "past http date", header: http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, want: 0},
+		{name: "milliseconds preferred", header: http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"3"}}, want: 250 * time.Millisecond},
+		{name: "invalid milliseconds fall back", header: http.Header{"Retry-After-Ms": {"soon"}, "Retry-After": {"2"}}, want: 2 * time.Second},
+		{name: "invalid", header: http.Header{"Retry-After": {"later"}}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("parseRetryAfter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLLMStatusError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		code      any
+		kind      LLMErrorKind
+		retryable bool
+	}{
+		{name: "rate limited", status: http.StatusTooManyRequests, kind: LLMErrorRateLimited, retryable: true},
+		{name: "quota exhausted", status: http.StatusTooManyRequests, code: "insufficient_quota", kind: LLMErrorUnavailable},
+		{name: "server error", status: http.StatusBadGateway, kind: LLMErrorUnavailable, retryable: true},
+		{name: "timeout", status: http.StatusRequestTimeout, kind: LLMErrorUnavailable, retryable: true},
+		{name: "bad request", status: http.StatusBadRequest, code: "context_length_exceeded", kind: LLMErrorBadResponse},
+		{name: "unauthorized", status: http.StatusUnauthorized, kind: LLMErrorBadResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body apiErrorBody
+			body.Error.Code = tt.code
+			llmErr := newLLMStatusError("openai", &http.Response{StatusCode: tt.status, Header: http.Header{}}, body)
+			if llmErr.Kind != tt.kind || llmErr.retryable() != tt.retryable {
+				t.Errorf("kind %s, retryable %t, want %s, %t", llmErr.Kind, llmErr.retryable(), tt.kind, tt.retryable)
+			}
+			if llmErr.Message == "" {
+				t.Error("message is empty")
+			}
+		})
+	}
+}
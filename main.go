@@ -1,17 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,21 +20,137 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"gocv.io/x/gocv"
 )
 
+type BoundingBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// TextElement keeps the original text/x/y shape for existing clients. The
+// geometry and recognition fields are only serialized when the client asks
+// for detail=full; the filter fields are set only by the LLM filters and are
+// kept in both detail levels. FilterConfidence is how sure the LLM filter was, from 0 to 1, that the
+// text is a store or food name, and TextCorrection records what the filter
+// changed.
 type TextElement struct {
-	Text string `json:"text"`
-	X    int    `json:"x"`
-	Y    int    `json:"y"`
+	Text             string       `json:"text"`
+	X                int          `json:"x"`
+	Y                int          `json:"y"`
+	Width            int          `json:"width,omitempty"`
+	Height           int          `json:"height,omitempty"`
+	BBox             *BoundingBox `json:"bbox,omitempty"`
+	RotatedBox       *RotatedBox  `json:"rotated_box,omitempty"`
+	Confidence       float64      `json:"confidence,omitempty"`
+	Source           string       `json:"source,omitempty"`
+	Language         string       `json:"language,omitempty"`
+	FilterConfidence float64      `json:"filter_confidence,omitempty"`
+	*TextCorrection
+
+	// imageIndex tags elements with the image they came from while a batch
+	// shares one filter call across several images.
+	imageIndex int
+	// words are the word boxes tesseract reported for the element, when it
+	// came from a full image pass.
+	words []OCRWord
+}
+
+// TextCorrection keeps what OCR read next to the spelling the LLM filter
+// returned. MatchScore is calculateMatchScore between the two, 1 when they
+// are the same.
+type TextCorrection struct {
+	OriginalText  string  `json:"original_text"`
+	CorrectedText string  `json:"corrected_text"`
+	MatchScore    float64 `json:"match_score"`
+	Corrected     bool    `json:"corrected"`
+}
+
+func newTextCorrection(original, corrected string) *TextCorrection {
+	original = strings.TrimSpace(original)
+	return &TextCorrection{
+		OriginalText:  original,
+		CorrectedText: corrected,
+		MatchScore:    calculateMatchScore(strings.ToLower(corrected), strings.ToLower(original)),
+		Corrected:     corrected != original,
+	}
+}
+
+// RotatedBox is the oriented box of text that does not run horizontally.
+// Angle is the counter-clockwise rotation of the baseline in degrees.
+type RotatedBox struct {
+	CenterX int     `json:"center_x"`
+	CenterY int     `json:"center_y"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Angle   float64 `json:"angle"`
+}
+
+const (
+	SourceFullImagePSM3 = "full_psm3"
+	SourceFullImagePSM6 = "full_psm6"
+	SourceRegionPSM8    = "region_psm8"
+)
+
+func newTextElement(text string, box image.Rectangle, confidence float64, source string) TextElement {
+	return TextElement{
+		Text:       text,
+		X:          box.Min.X + box.Dx()/2,
+		Y:          box.Min.Y + box.Dy()/2,
+		Width:      box.Dx(),
+		Height:     box.Dy(),
+		BBox:       &BoundingBox{X: box.Min.X, Y: box.Min.Y, Width: box.Dx(), Height: box.Dy()},
+		Confidence: confidence,
+		Source:     source,
+		Language:   detectTextLanguage(text),
+	}
+}
+
+// detectTextLanguage reports the script mix of a text using tesseract
+// language codes. Texts without Hangul or Latin letters return "".
+func detectTextLanguage(text string) string {
+	hasHangul, hasLatin := false, false
+	for _, r := range text {
+		if unicode.Is(unicode.Hangul, r) {
+			hasHangul = true
+		} else if unicode.Is(unicode.Latin, r) {
+			hasLatin = true
+		}
+	}
+
+	switch {
+	case hasHangul && hasLatin:
+		return "kor+eng"
+	case hasHangul:
+		return "kor"
+	case hasLatin:
+		return "eng"
+	}
+	return ""
+}
+
+// basicTextElements strips elements down to text, x and y, keeping the
+// filter confidence and correction of filtered requests.
+func basicTextElements(elements []TextElement) []TextElement {
+	basic := make([]TextElement, len(elements))
+	for i, elem := range elements {
+		basic[i] = TextElement{Text: elem.Text, X: elem.X, Y: elem.Y, FilterConfidence: elem.FilterConfidence, TextCorrection: elem.TextCorrection}
+	}
+	return basic
 }
 
 type OCRResponse struct {
-	Success    bool          `json:"success"`
-	TextList   []TextElement `json:"text_list"`
-	TotalCount int           `json:"total_count"`
-	Message    string        `json:"message,omitempty"`
+	Success     bool              `json:"success"`
+	TextList    []TextElement     `json:"text_list"`
+	TotalCount  int               `json:"total_count"`
+	Message     string            `json:"message,omitempty"`
+	ErrorCode   string            `json:"error_code,omitempty"`
+	Orientation *ImageOrientation `json:"orientation,omitempty"`
+	Layout      *DocumentLayout   `json:"layout,omitempty"`
+	MenuItems   []MenuItem        `json:"menu_items,omitempty"`
+	Degraded    bool              `json:"degraded,omitempty"`
 }
 
 type TextExtractRequest struct {
@@ -45,10 +162,22 @@ type TextExtractResponse struct {
 }
 
 type OpenAIRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature"`
-	MaxTokens   int       `json:"max_tokens"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Temperature    float64         `json:"temperature"`
+	MaxTokens      int             `json:"max_tokens"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type JSONSchemaFormat struct {
+	Name   string         `json:"name"`
+	Strict bool           `json:"strict"`
+	Schema map[string]any `json:"schema"`
 }
 
 type Message struct {
@@ -65,85 +194,151 @@ type Choice struct {
 }
 
 type OCRAnalyzer struct {
-	tesseractPath string
+	engine        OCREngine
+	detector      TextDetector
+	regionWorkers int
 	enabled       bool
 	mu            sync.RWMutex
 }
 
-func NewOCRAnalyzer() (*OCRAnalyzer, error) {
-	analyzer := &OCRAnalyzer{tesseractPath: "/usr/bin/tesseract", enabled: false}
-	log.Printf("[OCR INITIALIZATION] Starting OCR analyzer initialization process with tesseract path: %s", analyzer.tesseractPath)
+const (
+	SortByDiscovery    = ""
+	SortByConfidence   = "confidence"
+	SortByReadingOrder = "reading_order"
+)
 
-	if _, err := os.Stat(analyzer.tesseractPath); err != nil {
-		log.Printf("[OCR INITIALIZATION ERROR] Tesseract binary not found at expected path %s, system check failed with error: %v", analyzer.tesseractPath, err)
-		return nil, fmt.Errorf("tesseract not found")
-	}
+// highConfidenceThreshold lets short texts that tesseract is sure about pass
+// filtering even when they are not in the significant short text list.
+const highConfidenceThreshold = 85.0
 
-	tessDataPath := "/usr/share/tesseract-ocr/4.00/tessdata"
-	os.Setenv("TESSDATA_PREFIX", tessDataPath)
-	analyzer.enabled = true
+type ExtractOptions struct {
+	MinConfidence float64
+	SortBy        string
+	AutoRotate    bool
+	Profile       *PreprocessProfile
+	Ensemble      string
+}
+
+// ExtractionResult is what ExtractTexts found in one image. Text coordinates
+// are in the upright image described by Orientation.
+type ExtractionResult struct {
+	Texts       []TextElement
+	Orientation *ImageOrientation
+	Layout      *DocumentLayout
+	MenuItems   []MenuItem
+	// Degraded is set when the LLM filter was unavailable and a fallback
+	// filter was used instead.
+	Degraded bool
+}
 
-	log.Printf("[OCR INITIALIZATION SUCCESS] OCR analyzer successfully initialized, tesseract binary found, TESSDATA_PREFIX set to %s, analyzer enabled status: %t", tessDataPath, analyzer.enabled)
+func NewOCRAnalyzer(engine OCREngine, detector TextDetector, regionWorkers int) (*OCRAnalyzer, error) {
+	if engine == nil {
+		log.Printf("[OCR INITIALIZATION ERROR] No OCR engine supplied, analyzer cannot be initialized")
+		return nil, fmt.Errorf("OCR engine not configured")
+	}
+	if detector == nil {
+		log.Printf("[OCR INITIALIZATION ERROR] No text detector supplied, analyzer cannot be initialized")
+		return nil, fmt.Errorf("text detector not configured")
+	}
+	if regionWorkers < 1 {
+		regionWorkers = runtime.NumCPU()
+	}
+
+	analyzer := &OCRAnalyzer{engine: engine, detector: detector, regionWorkers: regionWorkers, enabled: true}
+	log.Printf("[OCR INITIALIZATION SUCCESS] OCR analyzer successfully initialized with engine %s, detector %s, region workers: %d, analyzer enabled status: %t", engine.Name(), detector.Name(), regionWorkers, analyzer.enabled)
 	return analyzer, nil
 }
 
-func (ocr *OCRAnalyzer) ExtractTexts(imagePath string) ([]TextElement, error) {
+func (ocr *OCRAnalyzer) ExtractTexts(ctx context.Context, imageData []byte, opts ExtractOptions) (*ExtractionResult, error) {
 	startTime := time.Now()
-	log.Printf("[OCR EXTRACTION START] Beginning text extraction process for image: %s at timestamp %v", imagePath, startTime)
+	log.Printf("[OCR EXTRACTION START] Beginning text extraction process for %d byte image at timestamp %v", len(imageData), startTime)
 
 	ocr.mu.RLock()
 	defer ocr.mu.RUnlock()
 
 	if !ocr.enabled {
-		log.Printf("[OCR EXTRACTION ERROR] OCR analyzer is not enabled, cannot proceed with text extraction for image: %s", imagePath)
+		log.Printf("[OCR EXTRACTION ERROR] OCR analyzer is not enabled, cannot proceed with text extraction")
 		return nil, fmt.Errorf("OCR not enabled")
 	}
 
-	img := gocv.IMRead(imagePath, gocv.IMReadColor)
-	if img.Empty() {
-		log.Printf("[OCR EXTRACTION ERROR] Failed to load image from path %s, image appears to be empty or corrupted", imagePath)
-		return nil, fmt.Errorf("failed to load image")
+	img, err := gocv.IMDecode(imageData, gocv.IMReadColor|gocv.IMReadIgnoreOrientation)
+	if err != nil || img.Empty() {
+		log.Printf("[OCR EXTRACTION ERROR] Failed to decode %d byte image, image appears to be empty or corrupted: %v", len(imageData), err)
+		img.Close()
+		return nil, fmt.Errorf("failed to decode image")
 	}
 	defer img.Close()
 
-	log.Printf("[OCR EXTRACTION INFO] Successfully loaded image %s with dimensions %dx%d, channels: %d", imagePath, img.Cols(), img.Rows(), img.Channels())
+	log.Printf("[OCR EXTRACTION INFO] Successfully decoded image with dimensions %dx%d, channels: %d", img.Cols(), img.Rows(), img.Channels())
+
+	orientation := ocr.orientImage(ctx, &img, imageData, opts.AutoRotate)
+	if err := ctx.Err(); err != nil {
+		log.Printf("[OCR EXTRACTION ABORTED] Request context ended during orientation detection: %v", err)
+		return nil, err
+	}
 
 	var results []TextElement
 
 	fullTextStart := time.Now()
-	fullText := ocr.recognizeFullImage(imagePath)
+	fullResult, fullSource := ocr.recognizeFullImage(ctx, img, opts.Ensemble)
 	fullTextDuration := time.Since(fullTextStart)
-	log.Printf("[OCR FULL IMAGE] Full image OCR completed in %v, raw text length: %d characters", fullTextDuration, len(fullText))
-
-	if fullText != "" {
-		cleanedText := ocr.cleanTesseractOutput(fullText)
-		log.Printf("[OCR FULL IMAGE] Text cleaning completed, original length: %d, cleaned length: %d", len(fullText), len(cleanedText))
+	log.Printf("[OCR FULL IMAGE] Full image OCR completed in %v, raw text length: %d characters, lines: %d", fullTextDuration, len(fullResult.Text), len(fullResult.Lines))
 
-		if cleanedText != "" && ocr.isValidText(cleanedText) {
-			centerX, centerY := img.Cols()/2, img.Rows()/2
-			results = append(results, TextElement{Text: cleanedText, X: centerX, Y: centerY})
-			log.Printf("[OCR FULL IMAGE] Valid text found and added to results: '%s' at position (%d, %d)", cleanedText, centerX, centerY)
+	for i, line := range fullResult.Lines {
+		lineText := strings.Join(strings.Fields(line.Text), " ")
+		if lineText == "" || !ocr.isValidText(lineText) || ocr.isDuplicateText(lineText, results) {
+			log.Printf("[OCR FULL IMAGE] Line %d rejected: '%s'", i+1, lineText)
+			continue
 		}
+
+		elem := newTextElement(lineText, line.Box, line.Confidence, fullSource)
+		elem.words = line.Words
+		results = append(results, elem)
+		log.Printf("[OCR FULL IMAGE] Line %d added: '%s' at position (%d, %d), bounds: (%d,%d)-(%d,%d), confidence: %.1f",
+			i+1, lineText, elem.X, elem.Y, line.Box.Min.X, line.Box.Min.Y, line.Box.Max.X, line.Box.Max.Y, line.Confidence)
+	}
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("[OCR EXTRACTION ABORTED] Request context ended after full image pass: %v", err)
+		return nil, err
 	}
 
 	regionDetectionStart := time.Now()
-	textRegions := ocr.detectTextRegions(img)
+	textRegions := mergeTextRegions(ocr.detector.Detect(img), regionMerge)
 	regionDetectionDuration := time.Since(regionDetectionStart)
 	log.Printf("[OCR REGION DETECTION] Text region detection completed in %v, found %d potential text regions", regionDetectionDuration, len(textRegions))
 
+	profile := opts.Profile
+	if profile == nil {
+		profile = preprocessProfiles[defaultProfileName]
+	}
+
+	recognitionStart := time.Now()
+	regionResults := ocr.recognizeRegions(ctx, img, textRegions, profile, opts.Ensemble)
+	log.Printf("[OCR REGION RECOGNITION] Recognized %d regions in %v using up to %d workers, preprocessing profile: %s", len(textRegions), time.Since(recognitionStart), ocr.regionWorkers, profile.Name)
+
+	if err := ctx.Err(); err != nil {
+		log.Printf("[OCR EXTRACTION ABORTED] Request context ended during region recognition: %v", err)
+		return nil, err
+	}
+
+	regionSource := SourceRegionPSM8
+	if opts.Ensemble != EnsembleOff {
+		regionSource = SourceRegionEnsemble
+	}
+
 	for i, region := range textRegions {
-		regionStart := time.Now()
-		text := ocr.recognizeTextInRegion(img, region)
-		regionDuration := time.Since(regionStart)
-		log.Printf("[OCR REGION %d] Region OCR completed in %v, region bounds: (%d,%d)-(%d,%d), raw text: '%s'",
-			i+1, regionDuration, region.Min.X, region.Min.Y, region.Max.X, region.Max.Y, text)
+		regionResult := regionResults[i]
 
-		cleanedText := ocr.cleanTesseractOutput(text)
+		cleanedText := ocr.cleanTesseractOutput(regionResult.Text)
 
 		if cleanedText != "" && ocr.isValidText(cleanedText) && !ocr.isDuplicateText(cleanedText, results) {
-			centerX, centerY := region.Min.X+region.Dx()/2, region.Min.Y+region.Dy()/2
-			results = append(results, TextElement{Text: cleanedText, X: centerX, Y: centerY})
-			log.Printf("[OCR REGION %d] Valid unique text added: '%s' at position (%d, %d)", i+1, cleanedText, centerX, centerY)
+			elem := newTextElement(cleanedText, region.Box, regionResult.Confidence, regionSource)
+			if region.Rotated() {
+				elem.RotatedBox = &RotatedBox{CenterX: region.Center.X, CenterY: region.Center.Y, Width: region.Width, Height: region.Height, Angle: region.Angle}
+			}
+			results = append(results, elem)
+			log.Printf("[OCR REGION %d] Valid unique text added: '%s' at position (%d, %d)", i+1, cleanedText, elem.X, elem.Y)
 		} else {
 			log.Printf("[OCR REGION %d] Text rejected - cleaned: '%s', valid: %t, duplicate: %t",
 				i+1, cleanedText, ocr.isValidText(cleanedText), ocr.isDuplicateText(cleanedText, results))
@@ -152,14 +347,15 @@ func (ocr *OCRAnalyzer) ExtractTexts(imagePath string) ([]TextElement, error) {
 
 	initialCount := len(results)
 	results = ocr.removeDuplicates(results)
-	results = ocr.filterValidTexts(results)
+	results = ocr.filterValidTexts(results, opts.MinConfidence)
+	results = sortTextElements(results, opts.SortBy)
 	finalCount := len(results)
 
 	totalDuration := time.Since(startTime)
 	log.Printf("[OCR EXTRACTION COMPLETE] Text extraction completed in %v, initial results: %d, final results: %d, duplicates removed: %d",
 		totalDuration, initialCount, finalCount, initialCount-len(ocr.removeDuplicates(results)))
 
-	return results, nil
+	return &ExtractionResult{Texts: results, Orientation: orientation}, nil
 }
 
 func (ocr *OCRAnalyzer) cleanTesseractOutput(rawText string) string {
@@ -235,19 +431,25 @@ func (ocr *OCRAnalyzer) cleanTesseractOutput(rawText string) string {
 	return result
 }
 
-func (ocr *OCRAnalyzer) filterValidTexts(elements []TextElement) []TextElement {
-	log.Printf("[OCR TEXT FILTERING] Starting text filtering process for %d elements", len(elements))
+func (ocr *OCRAnalyzer) filterValidTexts(elements []TextElement, minConfidence float64) []TextElement {
+	log.Printf("[OCR TEXT FILTERING] Starting text filtering process for %d elements, minimum confidence: %.1f", len(elements), minConfidence)
 	var filtered []TextElement
 
 	for i, elem := range elements {
 		text := strings.TrimSpace(elem.Text)
+		knownConfidence := elem.Confidence >= 0
 
 		if len(text) < 1 {
 			log.Printf("[OCR TEXT FILTERING] Element %d rejected: text too short (length < 1)", i+1)
 			continue
 		}
 
-		if len(text) <= 2 && !ocr.isSignificantShortText(text) {
+		if knownConfidence && elem.Confidence < minConfidence {
+			log.Printf("[OCR TEXT FILTERING] Element %d rejected: confidence %.1f below minimum %.1f for '%s'", i+1, elem.Confidence, minConfidence, text)
+			continue
+		}
+
+		if len(text) <= 2 && !ocr.isSignificantShortText(text) && !(knownConfidence && elem.Confidence >= highConfidenceThreshold) {
 			log.Printf("[OCR TEXT FILTERING] Element %d rejected: short text '%s' not significant", i+1, text)
 			continue
 		}
@@ -263,13 +465,58 @@ func (ocr *OCRAnalyzer) filterValidTexts(elements []TextElement) []TextElement {
 		}
 
 		filtered = append(filtered, elem)
-		log.Printf("[OCR TEXT FILTERING] Element %d accepted: '%s' at position (%d, %d)", i+1, text, elem.X, elem.Y)
+		log.Printf("[OCR TEXT FILTERING] Element %d accepted: '%s' at position (%d, %d), confidence: %.1f", i+1, text, elem.X, elem.Y, elem.Confidence)
 	}
 
 	log.Printf("[OCR TEXT FILTERING] Text filtering completed, %d elements passed filter out of %d initial elements", len(filtered), len(elements))
 	return filtered
 }
 
+// sortTextElements orders results by confidence (unknown confidence last) or
+// in reading order: top-to-bottom rows, left-to-right within a row. Elements
+// whose vertical centers fall within half a line height share a row.
+func sortTextElements(elements []TextElement, sortBy string) []TextElement {
+	switch sortBy {
+	case SortByConfidence:
+		sort.SliceStable(elements, func(i, j int) bool {
+			return elements[i].Confidence > elements[j].Confidence
+		})
+	case SortByReadingOrder:
+		sort.SliceStable(elements, func(i, j int) bool {
+			return elements[i].Y < elements[j].Y
+		})
+
+		var ordered, row []TextElement
+		rowY, rowHeight := 0, 0
+		flush := func() {
+			sort.SliceStable(row, func(i, j int) bool { return row[i].X < row[j].X })
+			ordered = append(ordered, row...)
+			row = nil
+		}
+		for _, elem := range elements {
+			if len(row) > 0 && abs(elem.Y-rowY) > max(rowHeight, elem.Height, 2)/2 {
+				flush()
+			}
+			if len(row) == 0 {
+				rowY, rowHeight = elem.Y, elem.Height
+			}
+			row = append(row, elem)
+		}
+		if len(row) > 0 {
+			flush()
+		}
+		elements = ordered
+	}
+	return elements
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
 func (ocr *OCRAnalyzer) isSignificantShortText(text string) bool {
 	if regexp.MustCompile(`^\d+$`).MatchString(text) {
 		return true
@@ -321,141 +568,109 @@ func (ocr *OCRAnalyzer) isRepeatingPattern(text string) bool {
 	return false
 }
 
-func (ocr *OCRAnalyzer) recognizeFullImage(imagePath string) string {
-	log.Printf("[OCR FULL RECOGNITION] Starting full image recognition for: %s", imagePath)
+// recognizeFullImage tries PSM 3 and then PSM 6, stopping at the first
+// non-empty result. In ensemble mode both are run and the vote decides.
+func (ocr *OCRAnalyzer) recognizeFullImage(ctx context.Context, img gocv.Mat, ensemble string) (OCRResult, string) {
+	log.Printf("[OCR FULL RECOGNITION] Starting full image recognition for image size %dx%d", img.Cols(), img.Rows())
 	psmModes := []string{"3", "6"}
+	sources := map[string]string{"3": SourceFullImagePSM3, "6": SourceFullImagePSM6}
+
+	if ensemble != EnsembleOff {
+		var candidates []ocrCandidate
+		for _, psm := range psmModes {
+			result, err := ocr.engine.Recognize(ctx, img, OCROptions{PSM: psm})
+			if err != nil || len(result.Lines) == 0 {
+				log.Printf("[OCR FULL RECOGNITION] Ensemble PSM mode %s failed or returned empty result", psm)
+				continue
+			}
+			candidates = append(candidates, ocrCandidate{Variant: "psm" + psm, Result: result})
+		}
+		best, variant, _ := voteOCRCandidates(candidates, EnsembleVoteConfidence)
+		if variant == "" {
+			return OCRResult{Confidence: -1}, ""
+		}
+		log.Printf("[OCR FULL RECOGNITION] Ensemble picked %s with confidence %.1f, extracted %d lines", variant, best.Confidence, len(best.Lines))
+		return best, SourceFullImageEnsemble
+	}
 
 	for i, psm := range psmModes {
 		log.Printf("[OCR FULL RECOGNITION] Attempting PSM mode %s (attempt %d/%d)", psm, i+1, len(psmModes))
-		text := ocr.runTesseract(imagePath, psm)
-		if text != "" && len(strings.TrimSpace(text)) > 0 {
-			log.Printf("[OCR FULL RECOGNITION] Success with PSM mode %s, extracted text length: %d", psm, len(text))
-			return text
+		result, err := ocr.engine.Recognize(ctx, img, OCROptions{PSM: psm})
+		if err == nil && len(result.Lines) > 0 {
+			log.Printf("[OCR FULL RECOGNITION] Success with PSM mode %s, extracted %d lines, text length: %d", psm, len(result.Lines), len(result.Text))
+			return result, sources[psm]
 		}
 		log.Printf("[OCR FULL RECOGNITION] PSM mode %s failed or returned empty result", psm)
 	}
 
-	log.Printf("[OCR FULL RECOGNITION] All PSM modes failed for image: %s", imagePath)
-	return ""
+	log.Printf("[OCR FULL RECOGNITION] All PSM modes failed for image size %dx%d", img.Cols(), img.Rows())
+	return OCRResult{Confidence: -1}, ""
 }
 
-func (ocr *OCRAnalyzer) recognizeTextInRegion(img gocv.Mat, region image.Rectangle) string {
-	log.Printf("[OCR REGION RECOGNITION] Processing region (%d,%d)-(%d,%d), size: %dx%d",
-		region.Min.X, region.Min.Y, region.Max.X, region.Max.Y, region.Dx(), region.Dy())
-
-	roi := img.Region(region)
-	if roi.Empty() {
-		log.Printf("[OCR REGION RECOGNITION] Region ROI is empty, skipping recognition")
-		return ""
+// recognizeRegions runs region recognition on a bounded pool of workers.
+// Results are stored by region index so callers see them in detection order.
+// In ensemble mode each region is read with every configured variant.
+func (ocr *OCRAnalyzer) recognizeRegions(ctx context.Context, img gocv.Mat, regions []TextRegion, profile *PreprocessProfile, ensemble string) []OCRResult {
+	results := make([]OCRResult, len(regions))
+	if len(regions) == 0 {
+		return results
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(ocr.regionWorkers, len(regions)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					results[i] = OCRResult{Confidence: -1}
+					continue
+				}
+				regionStart := time.Now()
+				region := regions[i]
+				if ensemble != EnsembleOff {
+					results[i] = ocr.recognizeRegionEnsemble(ctx, img, region, ensemble)
+				} else {
+					results[i] = ocr.recognizeTextInRegion(ctx, img, region, profile)
+				}
+				log.Printf("[OCR REGION %d] Region OCR completed in %v, region bounds: (%d,%d)-(%d,%d), angle: %.1f, raw text: '%s', confidence: %.1f",
+					i+1, time.Since(regionStart), region.Box.Min.X, region.Box.Min.Y, region.Box.Max.X, region.Box.Max.Y, region.Angle, results[i].Text, results[i].Confidence)
+			}
+		}()
 	}
-	defer roi.Close()
-
-	processed := ocr.basicPreprocess(roi)
-	defer processed.Close()
 
-	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("ocr_region_%d.png", time.Now().UnixNano()))
-	defer os.Remove(tempFile)
-
-	if !gocv.IMWrite(tempFile, processed) {
-		log.Printf("[OCR REGION RECOGNITION] Failed to save processed region to temporary file: %s", tempFile)
-		return ""
+	for i := range regions {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	log.Printf("[OCR REGION RECOGNITION] Saved processed region to temporary file: %s", tempFile)
-	result := ocr.runTesseract(tempFile, "8")
-	log.Printf("[OCR REGION RECOGNITION] Tesseract result for region: '%s'", result)
-	return result
+	return results
 }
 
-func (ocr *OCRAnalyzer) runTesseract(imagePath, psm string) string {
-	log.Printf("[OCR TESSERACT] Executing tesseract with image: %s, PSM: %s", imagePath, psm)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, ocr.tesseractPath, imagePath, "stdout", "-l", "kor+eng", "--psm", psm)
-	cmd.Env = append(os.Environ(), "TESSDATA_PREFIX=/usr/share/tesseract-ocr/4.00/tessdata")
-
-	startTime := time.Now()
-	output, err := cmd.CombinedOutput()
-	duration := time.Since(startTime)
+func (ocr *OCRAnalyzer) recognizeTextInRegion(ctx context.Context, img gocv.Mat, region TextRegion, profile *PreprocessProfile) OCRResult {
+	log.Printf("[OCR REGION RECOGNITION] Processing region (%d,%d)-(%d,%d), size: %dx%d, angle: %.1f",
+		region.Box.Min.X, region.Box.Min.Y, region.Box.Max.X, region.Box.Max.Y, region.Box.Dx(), region.Box.Dy(), region.Angle)
 
-	if err != nil {
-		log.Printf("[OCR TESSERACT] Tesseract execution failed after %v with error: %v, output: %s", duration, err, string(output))
-		return ""
+	roi := cropTextRegion(img, region)
+	defer roi.Close()
+	if roi.Empty() {
+		log.Printf("[OCR REGION RECOGNITION] Region ROI is empty, skipping recognition")
+		return OCRResult{Confidence: -1}
 	}
 
-	result := strings.TrimSpace(string(output))
-	log.Printf("[OCR TESSERACT] Tesseract execution completed successfully in %v, output length: %d characters", duration, len(result))
-	return result
-}
-
-func (ocr *OCRAnalyzer) detectTextRegions(img gocv.Mat) []image.Rectangle {
-	log.Printf("[OCR REGION DETECTION] Starting text region detection for image size %dx%d", img.Cols(), img.Rows())
-
-	gray := gocv.NewMat()
-	defer gray.Close()
-	gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
-
-	edges := gocv.NewMat()
-	defer edges.Close()
-	gocv.Canny(gray, &edges, 50, 150)
-
-	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Pt(10, 2))
-	defer kernel.Close()
-
-	connected := gocv.NewMat()
-	defer connected.Close()
-	gocv.MorphologyEx(edges, &connected, gocv.MorphClose, kernel)
-
-	contours := gocv.FindContours(connected, gocv.RetrievalExternal, gocv.ChainApproxSimple)
-	defer contours.Close()
-
-	log.Printf("[OCR REGION DETECTION] Found %d contours for analysis", contours.Size())
-
-	var regions []image.Rectangle
-	for i := 0; i < contours.Size(); i++ {
-		contour := contours.At(i)
-		area := gocv.ContourArea(contour)
-
-		if area > 100 && area < 50000 {
-			rect := gocv.BoundingRect(contour)
+	processed := profile.Run(roi)
+	defer processed.Close()
+	log.Printf("[OCR PREPROCESSING] Profile %s applied, original size: %dx%d, final size: %dx%d", profile.Name, roi.Cols(), roi.Rows(), processed.Cols(), processed.Rows())
 
-			if rect.Dx() > 15 && rect.Dy() > 8 && rect.Dy() < 100 {
-				padding := 5
-				expandedRect := image.Rect(max(0, rect.Min.X-padding), max(0, rect.Min.Y-padding), min(img.Cols(), rect.Max.X+padding), min(img.Rows(), rect.Max.Y+padding))
-				regions = append(regions, expandedRect)
-				log.Printf("[OCR REGION DETECTION] Valid region %d: area=%.0f, bounds=(%d,%d)-(%d,%d), expanded=(%d,%d)-(%d,%d)",
-					len(regions), area, rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y,
-					expandedRect.Min.X, expandedRect.Min.Y, expandedRect.Max.X, expandedRect.Max.Y)
-			} else {
-				log.Printf("[OCR REGION DETECTION] Rejected contour %d: area=%.0f, size=%dx%d (too small/large)", i+1, area, rect.Dx(), rect.Dy())
-			}
-		} else {
-			log.Printf("[OCR REGION DETECTION] Rejected contour %d: area=%.0f (outside valid range 100-50000)", i+1, area)
-		}
+	result, err := ocr.engine.Recognize(ctx, processed, OCROptions{PSM: "8"})
+	if err != nil {
+		log.Printf("[OCR REGION RECOGNITION] Engine %s failed for region: %v", ocr.engine.Name(), err)
+		return OCRResult{Confidence: -1}
 	}
 
-	log.Printf("[OCR REGION DETECTION] Region detection completed, found %d valid text regions", len(regions))
-	return regions
-}
-
-func (ocr *OCRAnalyzer) basicPreprocess(roi gocv.Mat) gocv.Mat {
-	log.Printf("[OCR PREPROCESSING] Starting preprocessing for ROI size %dx%d", roi.Cols(), roi.Rows())
-
-	gray := gocv.NewMat()
-	defer gray.Close()
-	gocv.CvtColor(roi, &gray, gocv.ColorBGRToGray)
-
-	enlarged := gocv.NewMat()
-	defer enlarged.Close()
-	newSize := image.Pt(roi.Cols()*2, roi.Rows()*2)
-	gocv.Resize(gray, &enlarged, newSize, 0, 0, gocv.InterpolationCubic)
-
-	result := gocv.NewMat()
-	gocv.AdaptiveThreshold(enlarged, &result, 255, gocv.AdaptiveThresholdGaussian, gocv.ThresholdBinary, 11, 2)
-
-	log.Printf("[OCR PREPROCESSING] Preprocessing completed, original size: %dx%d, final size: %dx%d", roi.Cols(), roi.Rows(), result.Cols(), result.Rows())
+	log.Printf("[OCR REGION RECOGNITION] Engine result for region: '%s', confidence: %.1f", result.Text, result.Confidence)
 	return result
 }
 
@@ -510,61 +725,7 @@ func (ocr *OCRAnalyzer) isValidText(text string) bool {
 	return hasValidChar
 }
 
-func callOpenAI(prompt string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY environment variable not set")
-	}
-
-	requestBody := OpenAIRequest{
-		Model:       "gpt-4o-mini",
-		Temperature: 0.1,
-		MaxTokens:   150,
-		Messages: []Message{
-			{
-				Role:    "system",
-				Content: "You are a precise OCR text analysis specialist with expertise in Korean text recognition errors. Follow instructions exactly. Return only the requested information without explanations, formatting, or additional text. Handle OCR recognition errors intelligently.",
-			},
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var openAIResp OpenAIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
-		return "", err
-	}
-
-	if len(openAIResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
-	}
-
-	return strings.TrimSpace(openAIResp.Choices[0].Message.Content), nil
-}
-
-func extractStoreNameFromText(text string) (string, error) {
+func extractStoreNameFromText(ctx context.Context, text string) (string, error) {
 	prompt := fmt.Sprintf(`TASK: Extract the exact store/restaurant name from stuttered speech.
 
 CONTEXT: Users often stutter when saying store names. Your job is to identify the core business name, removing filler words and repetitions.
@@ -599,10 +760,10 @@ Output: NONE
 INPUT TEXT: "%s"
 OUTPUT:`, text)
 
-	return callOpenAI(prompt)
+	return callLLM(ctx, LLMRequest{Task: TaskExtractStore, Prompt: prompt, Input: text})
 }
 
-func extractNumberFromText(text string) (string, error) {
+func extractNumberFromText(ctx context.Context, text string) (string, error) {
 	prompt := fmt.Sprintf(`TASK: Extract the specific number mentioned in stuttered speech.
 
 CONTEXT: Users stutter when trying to say numbers. Extract the exact number they're attempting to communicate.
@@ -634,10 +795,10 @@ Output: NONE
 INPUT TEXT: "%s"
 OUTPUT:`, text)
 
-	return callOpenAI(prompt)
+	return callLLM(ctx, LLMRequest{Task: TaskExtractNumber, Prompt: prompt, Input: text})
 }
 
-func extractFoodNameFromText(text string) (string, error) {
+func extractFoodNameFromText(ctx context.Context, text string) (string, error) {
 	prompt := fmt.Sprintf(`TASK: Extract the exact food/menu item name from stuttered speech.
 
 CONTEXT: Users stutter when ordering food. Extract the specific food/menu item they want to order.
@@ -670,33 +831,32 @@ Output: NONE
 INPUT TEXT: "%s"
 OUTPUT:`, text)
 
-	return callOpenAI(prompt)
+	return callLLM(ctx, LLMRequest{Task: TaskExtractFood, Prompt: prompt, Input: text})
 }
 
-func filterStoreNames(textList []TextElement) ([]TextElement, error) {
+func filterStoreNames(ctx context.Context, textList []TextElement) ([]TextElement, error) {
 	if len(textList) == 0 {
 		return []TextElement{}, nil
 	}
 
-	var allTexts []string
-	for _, item := range textList {
-		allTexts = append(allTexts, fmt.Sprintf("\"%s\"", item.Text))
-	}
-
 	prompt := fmt.Sprintf(`TASK: Identify store/restaurant names from OCR text results with error correction.
 
 CONTEXT: This is text extracted from images (signs, menus, etc.) using OCR technology. OCR often makes recognition errors, especially with Korean text.
 
-TEXT LIST: [%s]
+TEXT LIST (index: text):
+%s
 
 RULES:
 1. Identify text that represents store/restaurant/business names
 2. Handle OCR recognition errors intelligently and provide corrected names
 3. Exclude: prices, menu descriptions, addresses, phone numbers, hours, promotional text
 4. Include: brand names, restaurant names, store names, franchise names
-5. Return results as comma-separated values with corrected spelling
-6. Keep original meaning but fix OCR errors
-7. If no store names found, return "NONE"
+5. Keep original meaning but fix OCR errors
+6. Reply with a JSON object {"items": [...]} holding one entry per store name:
+   - original_index: the index of the text in TEXT LIST
+   - corrected_text: the name with spelling corrected
+   - confidence: how sure you are that the text is a store name, from 0 to 1
+7. If no store names found, reply {"items": []}
 
 OCR ERROR CORRECTION EXAMPLES:
 - "맥도냘드" → "맥도날드"
@@ -707,49 +867,63 @@ OCR ERROR CORRECTION EXAMPLES:
 - "롯떼리아" → "롯데리아"
 
 ANALYSIS EXAMPLES:
-Input: ["맥도날드", "빅맥세트", "5,500원", "영업시간", "02-123-4567"]
-Output: 맥도날드
-
-Input: ["스따벅스", "아메리카노", "4,500원", "카페라떼", "매장안내"]  
-Output: 스타벅스
-
-Input: ["BBQ", "황금올리브치킨", "반반치킨", "17,000원", "배달가능"]
-Output: BBQ
-
-OUTPUT:`, strings.Join(allTexts, ", "))
-
-	result, err := callOpenAI(prompt)
+Input:
+0: "맥도날드"
+1: "빅맥세트"
+2: "5,500원"
+3: "영업시간"
+4: "02-123-4567"
+Output: {"items": [{"original_index": 0, "corrected_text": "맥도날드", "confidence": 0.98}]}
+
+Input:
+0: "스따벅스"
+1: "아메리카노"
+2: "4,500원"
+3: "카페라떼"
+4: "매장안내"
+Output: {"items": [{"original_index": 0, "corrected_text": "스타벅스", "confidence": 0.93}]}
+
+Input:
+0: "BBQ"
+1: "황금올리브치킨"
+2: "반반치킨"
+3: "17,000원"
+4: "배달가능"
+Output: {"items": [{"original_index": 0, "corrected_text": "BBQ", "confidence": 0.95}]}
+
+OUTPUT:`, filterPromptItems(textList))
+
+	result, err := callLLM(ctx, LLMRequest{Task: TaskFilterStores, Prompt: prompt, Items: itemTexts(textList), Schema: filterReplySchema, Validate: validateFilterReply, MaxTokens: filterReplyMaxTokens(len(textList))})
 	if err != nil {
 		return nil, err
 	}
 
-	return filterTextItemsAdvanced(textList, result), nil
+	return applyFilterReply(textList, result)
 }
 
-func filterFoodNames(textList []TextElement) ([]TextElement, error) {
+func filterFoodNames(ctx context.Context, textList []TextElement) ([]TextElement, error) {
 	if len(textList) == 0 {
 		return []TextElement{}, nil
 	}
 
-	var allTexts []string
-	for _, item := range textList {
-		allTexts = append(allTexts, fmt.Sprintf("\"%s\"", item.Text))
-	}
-
 	prompt := fmt.Sprintf(`TASK: Identify food/menu item names from OCR text results with error correction.
 
 CONTEXT: This is text extracted from menu images using OCR technology. OCR often makes recognition errors, especially with Korean food names.
 
-TEXT LIST: [%s]
+TEXT LIST (index: text):
+%s
 
 RULES:
 1. Identify text that represents food items, dishes, beverages, menu items
 2. Handle OCR recognition errors intelligently and provide corrected names
 3. Exclude: store names, prices, promotional text, descriptions, categories
 4. Include: specific food names, drink names, dish names, menu items
-5. Return results as comma-separated values with corrected spelling
-6. Keep original meaning but fix OCR errors
-7. If no food names found, return "NONE"
+5. Keep original meaning but fix OCR errors
+6. Reply with a JSON object {"items": [...]} holding one entry per food name:
+   - original_index: the index of the text in TEXT LIST
+   - corrected_text: the name with spelling corrected, without any price
+   - confidence: how sure you are that the text is a food name, from 0 to 1
+7. If no food names found, reply {"items": []}
 
 OCR ERROR CORRECTION EXAMPLES:
 - "비맥세트" → "빅맥세트"
@@ -761,23 +935,177 @@ OCR ERROR CORRECTION EXAMPLES:
 - "화이트모까" → "화이트모카"
 
 ANALYSIS EXAMPLES:
-Input: ["맥도날드", "비맥세트", "5,500원", "지즈버거", "콜라"]
-Output: 빅맥세트, 치즈버거, 콜라
+Input:
+0: "맥도날드"
+1: "비맥세트"
+2: "5,500원"
+3: "지즈버거"
+4: "콜라"
+Output: {"items": [{"original_index": 1, "corrected_text": "빅맥세트", "confidence": 0.92}, {"original_index": 3, "corrected_text": "치즈버거", "confidence": 0.9}, {"original_index": 4, "corrected_text": "콜라", "confidence": 0.97}]}
+
+Input:
+0: "스타벅스"
+1: "아메리가노"
+2: "4,500원"
+3: "까페라떼"
+4: "매장안내"
+Output: {"items": [{"original_index": 1, "corrected_text": "아메리카노", "confidence": 0.94}, {"original_index": 3, "corrected_text": "카페라떼", "confidence": 0.9}]}
+
+Input:
+0: "BBQ"
+1: "황금올리브치킨"
+2: "뿌링끌"
+3: "17,000원"
+4: "배달가능"
+Output: {"items": [{"original_index": 1, "corrected_text": "황금올리브치킨", "confidence": 0.96}, {"original_index": 2, "corrected_text": "뿌링클", "confidence": 0.88}]}
+
+OUTPUT:`, filterPromptItems(textList))
+
+	result, err := callLLM(ctx, LLMRequest{Task: TaskFilterFoods, Prompt: prompt, Items: itemTexts(textList), Schema: filterReplySchema, Validate: validateFilterReply, MaxTokens: filterReplyMaxTokens(len(textList))})
+	if err != nil {
+		return nil, err
+	}
 
-Input: ["스타벅스", "아메리가노", "4,500원", "까페라떼", "매장안내"]
-Output: 아메리카노, 카페라떼
+	return applyFilterReply(textList, result)
+}
+
+func itemTexts(textList []TextElement) []string {
+	texts := make([]string, len(textList))
+	for i, item := range textList {
+		texts[i] = item.Text
+	}
+	return texts
+}
+
+func filterPromptItems(textList []TextElement) string {
+	lines := make([]string, len(textList))
+	for i, item := range textList {
+		lines[i] = fmt.Sprintf("%d: %q", i, item.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// filterReplyMaxTokens leaves room for every text to be returned, since a
+// structured reply cut short cannot be parsed at all.
+func filterReplyMaxTokens(items int) int {
+	return min(4096, 64+48*items)
+}
+
+// filterReply is the structured reply of the store and food filters. Each
+// item points back at the text it was read from by its index in the list
+// sent to the model.
+type filterReply struct {
+	Items []filterReplyItem `json:"items"`
+}
 
-Input: ["BBQ", "황금올리브치킨", "뿌링끌", "17,000원", "배달가능"]
-Output: 황금올리브치킨, 뿌링클
+type filterReplyItem struct {
+	OriginalIndex int     `json:"original_index"`
+	CorrectedText string  `json:"corrected_text"`
+	Confidence    float64 `json:"confidence"`
+}
+
+var filterReplySchema = &ResponseSchema{
+	Name: "filtered_texts",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"original_index": map[string]any{"type": "integer"},
+						"corrected_text": map[string]any{"type": "string"},
+						"confidence":     map[string]any{"type": "number"},
+					},
+					"required":             []string{"original_index", "corrected_text", "confidence"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"items"},
+		"additionalProperties": false,
+	},
+}
+
+// parseFilterReply decodes a structured filter reply. A reply that is not
+// JSON, has fields outside the schema or has no items list is an error.
+func parseFilterReply(reply string) (filterReply, error) {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "```") {
+		// Some OpenAI compatible servers ignore the response format and
+		// wrap the JSON in a code fence.
+		reply = strings.TrimPrefix(strings.TrimPrefix(reply, "```json"), "```")
+		reply = strings.TrimSpace(strings.TrimSuffix(reply, "```"))
+	}
+
+	var parsed filterReply
+	decoder := json.NewDecoder(strings.NewReader(reply))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return parsed, fmt.Errorf("invalid filter reply: %w", err)
+	}
+	if parsed.Items == nil {
+		return parsed, fmt.Errorf("invalid filter reply: no items list")
+	}
+	return parsed, nil
+}
 
-OUTPUT:`, strings.Join(allTexts, ", "))
+func validateFilterReply(reply string) error {
+	_, err := parseFilterReply(reply)
+	return err
+}
 
-	result, err := callOpenAI(prompt)
+// applyFilterReply parses a structured filter reply and returns the source
+// elements it selected, in reply order, carrying the corrected text. Entries
+// that point outside the list, repeat an index, have no text or a confidence
+// outside 0..1 are dropped. A reply that parseFilterReply rejects is an
+// LLMErrorBadResponse, so it is answered with 502 or the degraded fallback
+// like any other unusable model reply.
+func applyFilterReply(originalItems []TextElement, reply string) ([]TextElement, error) {
+	parsed, err := parseFilterReply(reply)
 	if err != nil {
-		return nil, err
+		provider := "llm"
+		if llmProvider != nil {
+			provider = llmProvider.Name()
+		}
+		return nil, &LLMError{Kind: LLMErrorBadResponse, Provider: provider, Message: "unusable filter reply", Err: err}
+	}
+
+	result := []TextElement{}
+	seen := make(map[int]bool)
+	corrected := 0
+	for _, item := range parsed.Items {
+		text := strings.TrimSpace(item.CorrectedText)
+		switch {
+		case item.OriginalIndex < 0 || item.OriginalIndex >= len(originalItems):
+			log.Printf("[LLM FILTER] Dropping reply item with index %d outside %d texts", item.OriginalIndex, len(originalItems))
+			continue
+		case seen[item.OriginalIndex]:
+			log.Printf("[LLM FILTER] Dropping repeated reply item for index %d", item.OriginalIndex)
+			continue
+		case text == "":
+			log.Printf("[LLM FILTER] Dropping reply item for index %d without text", item.OriginalIndex)
+			continue
+		case item.Confidence < 0 || item.Confidence > 1:
+			log.Printf("[LLM FILTER] Dropping reply item for index %d with confidence %.2f", item.OriginalIndex, item.Confidence)
+			continue
+		}
+		seen[item.OriginalIndex] = true
+
+		matched := originalItems[item.OriginalIndex]
+		matched.TextCorrection = newTextCorrection(matched.Text, text)
+		matched.Text = text
+		matched.FilterConfidence = item.Confidence
+		result = append(result, matched)
+		if matched.Corrected {
+			corrected++
+			log.Printf("[LLM FILTER] Corrected '%s' to '%s', match score: %.2f", matched.OriginalText, text, matched.MatchScore)
+		}
 	}
 
-	return filterTextItemsAdvanced(textList, result), nil
+	log.Printf("[LLM FILTER] Reply selected %d of %d texts, %d corrected", len(result), len(originalItems), corrected)
+	return result, nil
 }
 
 func filterTextItems(originalItems []TextElement, filteredTexts string) []TextElement {
@@ -827,22 +1155,18 @@ func filterTextItemsImproved(originalItems []TextElement, filteredTexts string)
 			itemText := strings.TrimSpace(item.Text)
 
 			if strings.EqualFold(itemText, cleanText) {
-				result = append(result, TextElement{
-					Text: cleanText,
-					X:    item.X,
-					Y:    item.Y,
-				})
+				matched := item
+				matched.Text = cleanText
+				result = append(result, matched)
 				seen[cleanText] = true
 				break
 			}
 
 			if strings.Contains(strings.ToLower(itemText), strings.ToLower(cleanText)) {
 				if isWordBoundaryMatch(cleanText, itemText) {
-					result = append(result, TextElement{
-						Text: cleanText,
-						X:    item.X,
-						Y:    item.Y,
-					})
+					matched := item
+					matched.Text = cleanText
+					result = append(result, matched)
 					seen[cleanText] = true
 					break
 				}
@@ -853,57 +1177,6 @@ func filterTextItemsImproved(originalItems []TextElement, filteredTexts string)
 	return result
 }
 
-func filterTextItemsAdvanced(originalItems []TextElement, filteredTexts string) []TextElement {
-	if filteredTexts == "NONE" || strings.TrimSpace(filteredTexts) == "" {
-		return []TextElement{}
-	}
-
-	var result []TextElement
-	seen := make(map[string]bool)
-
-	filteredList := strings.Split(filteredTexts, ",")
-
-	for _, filteredText := range filteredList {
-		cleanText := strings.TrimSpace(filteredText)
-		cleanText = strings.Trim(cleanText, "\"'")
-
-		if cleanText == "" || seen[cleanText] {
-			continue
-		}
-
-		bestMatch := findBestMatchAdvanced(cleanText, originalItems)
-		if bestMatch != nil {
-			result = append(result, TextElement{
-				Text: cleanText,
-				X:    bestMatch.X,
-				Y:    bestMatch.Y,
-			})
-			seen[cleanText] = true
-		}
-	}
-
-	return result
-}
-
-func findBestMatchAdvanced(targetText string, items []TextElement) *TextElement {
-	var bestMatch *TextElement
-	maxScore := 0.0
-
-	targetLower := strings.ToLower(targetText)
-
-	for _, item := range items {
-		itemLower := strings.ToLower(item.Text)
-		score := calculateMatchScore(targetLower, itemLower)
-
-		if score > maxScore && score > 0.3 {
-			maxScore = score
-			bestMatch = &item
-		}
-	}
-
-	return bestMatch
-}
-
 func calculateMatchScore(target, source string) float64 {
 	if target == source {
 		return 1.0
@@ -1002,78 +1275,289 @@ func min3(a, b, c int) int {
 
 var analyzer *OCRAnalyzer
 
-func imageExtractHandler(c *gin.Context) {
-	requestStart := time.Now()
-	clientIP := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-	log.Printf("[HTTP REQUEST] OCR extraction request received from client IP: %s, User-Agent: %s, timestamp: %v", clientIP, userAgent, requestStart)
+var ocrEngineLimiter *LimitedOCREngine
 
-	filterType := c.Query("type")
-	log.Printf("[HTTP REQUEST] Filter type: '%s'", filterType)
+var ocrQueue *AdmissionQueue
 
-	if filterType != "" && filterType != "store" && filterType != "food" {
-		log.Printf("[HTTP REQUEST ERROR] Invalid filter type: %s", filterType)
-		c.JSON(http.StatusBadRequest, OCRResponse{
-			Success: false,
-			Message: "type parameter must be 'store' or 'food'",
-		})
-		return
+var defaultMinConfidence float64
+
+var requestTimeout = 120 * time.Second
+
+// abortedByContext handles OCR requests that stopped because their context
+// ended: a disconnected client gets no response, a deadline gets a 504.
+func abortedByContext(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Printf("[HTTP REQUEST] Client IP: %s disconnected, work for the request was cancelled", c.ClientIP())
+		c.Abort()
+		return true
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, OCRResponse{Success: false, Message: extractionErrorMessage(err), ErrorCode: ErrorCodeTimeout})
+		return true
 	}
+	return false
+}
 
-	file, err := c.FormFile("image")
+func parseExtractOptions(c *gin.Context) (ExtractOptions, error) {
+	opts := ExtractOptions{MinConfidence: defaultMinConfidence, SortBy: c.Query("sort"), AutoRotate: autoRotateDefault}
+
+	if value := c.Query("min_confidence"); value != "" {
+		minConfidence, err := strconv.ParseFloat(value, 64)
+		if err != nil || minConfidence < 0 || minConfidence > 100 {
+			return opts, fmt.Errorf("min_confidence must be a number between 0 and 100")
+		}
+		opts.MinConfidence = minConfidence
+	}
+
+	if opts.SortBy != SortByDiscovery && opts.SortBy != SortByConfidence && opts.SortBy != SortByReadingOrder {
+		return opts, fmt.Errorf("sort parameter must be 'confidence' or 'reading_order'")
+	}
+
+	opts.Ensemble = c.Query("ensemble")
+	if opts.Ensemble != EnsembleOff && opts.Ensemble != EnsembleVoteConfidence && opts.Ensemble != EnsembleVoteAgreement {
+		return opts, fmt.Errorf("ensemble parameter must be 'confidence' or 'agreement'")
+	}
+
+	profile, err := lookupPreprocessProfile(c.Query("profile"))
 	if err != nil {
-		log.Printf("[HTTP REQUEST ERROR] Failed to retrieve image file from request, client IP: %s, error: %v", clientIP, err)
-		c.JSON(http.StatusBadRequest, OCRResponse{Success: false, Message: "Image file required"})
-		return
+		return opts, err
 	}
+	opts.Profile = profile
 
-	log.Printf("[HTTP REQUEST] Image file received: filename='%s', size=%d bytes, content-type='%s'", file.Filename, file.Size, file.Header.Get("Content-Type"))
+	if value := c.Query("auto_rotate"); value != "" {
+		autoRotate, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("auto_rotate must be 'true' or 'false'")
+		}
+		opts.AutoRotate = autoRotate
+	}
 
-	imagePath := filepath.Join(os.TempDir(), fmt.Sprintf("ocr_%s.png", uuid.New().String()[:8]))
-	defer os.Remove(imagePath)
+	return opts, nil
+}
 
-	if err := c.SaveUploadedFile(file, imagePath); err != nil {
-		log.Printf("[HTTP REQUEST ERROR] Failed to save uploaded file to temporary path %s, client IP: %s, error: %v", imagePath, clientIP, err)
-		c.JSON(http.StatusInternalServerError, OCRResponse{Success: false, Message: "Failed to save image"})
-		return
+// ImageExtractParams holds the query options shared by every image
+// extraction endpoint.
+type ImageExtractParams struct {
+	FilterType string
+	Detail     string
+	Layout     string
+	Options    ExtractOptions
+}
+
+func parseImageExtractParams(c *gin.Context) (ImageExtractParams, error) {
+	params := ImageExtractParams{FilterType: c.Query("type"), Detail: c.DefaultQuery("detail", "basic"), Layout: c.DefaultQuery("layout", LayoutFlat)}
+
+	if params.FilterType != "" && params.FilterType != "store" && params.FilterType != "food" && params.FilterType != "menu" {
+		return params, fmt.Errorf("type parameter must be 'store', 'food' or 'menu'")
+	}
+
+	if params.Detail != "basic" && params.Detail != "full" {
+		return params, fmt.Errorf("detail parameter must be 'basic' or 'full'")
 	}
 
-	log.Printf("[HTTP REQUEST] Image successfully saved to temporary file: %s, proceeding with OCR analysis", imagePath)
+	if params.Layout != LayoutFlat && params.Layout != LayoutStructured {
+		return params, fmt.Errorf("layout parameter must be 'flat' or 'structured'")
+	}
 
-	texts, err := analyzer.ExtractTexts(imagePath)
+	opts, err := parseExtractOptions(c)
 	if err != nil {
-		log.Printf("[HTTP REQUEST ERROR] OCR analysis failed for image %s, client IP: %s, error: %v", imagePath, clientIP, err)
-		c.JSON(http.StatusInternalServerError, OCRResponse{Success: false, Message: "OCR failed"})
-		return
+		return params, err
+	}
+	params.Options = opts
+
+	return params, nil
+}
+
+// extractionError carries the message and error code that are safe to show
+// to clients alongside the underlying cause.
+type extractionError struct {
+	Message string
+	Code    string
+	Err     error
+}
+
+func (e *extractionError) Error() string {
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *extractionError) Unwrap() error {
+	return e.Err
+}
+
+// runImageExtraction runs OCR on an in-memory image and applies the requested
+// filter. It is shared by the synchronous handler and background jobs.
+func runImageExtraction(ctx context.Context, imageData []byte, params ImageExtractParams) (*ExtractionResult, error) {
+	extraction, err := analyzer.ExtractTexts(ctx, imageData, params.Options)
+	if err != nil {
+		log.Printf("[OCR PIPELINE ERROR] OCR analysis failed for %d byte image, error: %v", len(imageData), err)
+		return nil, &extractionError{Message: "OCR failed", Code: ErrorCodeOCRFailed, Err: err}
+	}
+
+	finalTexts, degraded, err := applyTextFilter(ctx, params.FilterType, extraction.Texts)
+	if err != nil {
+		return nil, err
+	}
+	extraction.Degraded = degraded
+
+	if params.FilterType == "menu" {
+		extraction.MenuItems = pairMenuPrices(finalTexts, extraction.Texts)
+	}
+	if params.Layout == LayoutStructured {
+		extraction.Layout = buildDocumentLayout(finalTexts)
+	}
+	if params.Detail == "basic" {
+		finalTexts = basicTextElements(finalTexts)
 	}
+	extraction.Texts = finalTexts
+	return extraction, nil
+}
 
-	var finalTexts []TextElement
-	if filterType == "" {
-		finalTexts = texts
-		log.Printf("[HTTP REQUEST] No filtering applied, returning %d text elements", len(finalTexts))
-	} else {
-		switch filterType {
-		case "store":
-			finalTexts, err = filterStoreNames(texts)
-			if err != nil {
-				log.Printf("[HTTP REQUEST ERROR] Store name filtering failed: %v", err)
-				c.JSON(http.StatusInternalServerError, OCRResponse{Success: false, Message: "Store name filtering failed"})
-				return
+// applyTextFilter runs the requested LLM filter. When the LLM fails and a
+// fallback is configured, it returns the fallback result and reports it as
+// degraded instead of failing.
+func applyTextFilter(ctx context.Context, filterType string, texts []TextElement) ([]TextElement, bool, error) {
+	switch filterType {
+	case "store":
+		filtered, err := filterStoreNames(ctx, texts)
+		if err != nil {
+			if fallback, ok := degradedTextFilter(err, texts); ok {
+				return fallback, true, nil
 			}
-			log.Printf("[HTTP REQUEST] Store name filtering applied, %d elements filtered from %d", len(finalTexts), len(texts))
-		case "food":
-			finalTexts, err = filterFoodNames(texts)
-			if err != nil {
-				log.Printf("[HTTP REQUEST ERROR] Food name filtering failed: %v", err)
-				c.JSON(http.StatusInternalServerError, OCRResponse{Success: false, Message: "Food name filtering failed"})
-				return
+			log.Printf("[OCR PIPELINE ERROR] Store name filtering failed: %v", err)
+			return nil, false, &extractionError{Message: "Store name filtering failed", Code: ErrorCodeFilterFailed, Err: err}
+		}
+		log.Printf("[OCR PIPELINE] Store name filtering applied, %d elements filtered from %d", len(filtered), len(texts))
+		return filtered, false, nil
+	case "food", "menu":
+		filtered, err := filterFoodNames(ctx, texts)
+		if err != nil {
+			if fallback, ok := degradedTextFilter(err, texts); ok {
+				return fallback, true, nil
 			}
-			log.Printf("[HTTP REQUEST] Food name filtering applied, %d elements filtered from %d", len(finalTexts), len(texts))
+			log.Printf("[OCR PIPELINE ERROR] Food name filtering failed: %v", err)
+			return nil, false, &extractionError{Message: "Food name filtering failed", Code: ErrorCodeFilterFailed, Err: err}
+		}
+		log.Printf("[OCR PIPELINE] Food name filtering applied, %d elements filtered from %d", len(filtered), len(texts))
+		return filtered, false, nil
+	}
+
+	log.Printf("[OCR PIPELINE] No filtering applied, returning %d text elements", len(texts))
+	return texts, false, nil
+}
+
+func inputErrorStatus(err error) int {
+	var inputErr *inputError
+	if errors.As(err, &inputErr) {
+		return inputErr.Status
+	}
+	return http.StatusBadRequest
+}
+
+func inputErrorCode(err error) string {
+	var inputErr *inputError
+	if errors.As(err, &inputErr) {
+		return inputErr.Code
+	}
+	return ""
+}
+
+// extractionErrorStatus answers LLM failures with 429, 502 or 503, a full
+// or slow admission queue with 429 or 503, a deadline with 504 and
+// everything else with 500.
+func extractionErrorStatus(err error) int {
+	if status, ok := llmErrorStatus(err); ok {
+		return status
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errQueueTimeout):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func extractionErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Request timed out"
+	case errors.Is(err, errQueueFull):
+		return errQueueFull.Error()
+	case errors.Is(err, errQueueTimeout):
+		return errQueueTimeout.Error()
+	}
+	var extractErr *extractionError
+	if errors.As(err, &extractErr) {
+		return extractErr.Message
+	}
+	return "OCR failed"
+}
+
+// extractionErrorCode is the error_code for a failed extraction, used the
+// same way by the single image, batch and job paths.
+func extractionErrorCode(err error) string {
+	if code := llmErrorCode(err); code != "" {
+		return code
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeTimeout
+	case errors.Is(err, errQueueFull):
+		return ErrorCodeQueueFull
+	case errors.Is(err, errQueueTimeout):
+		return ErrorCodeQueueTimeout
+	}
+	var extractErr *extractionError
+	if errors.As(err, &extractErr) && extractErr.Code != "" {
+		return extractErr.Code
+	}
+	return ErrorCodeOCRFailed
+}
+
+func imageExtractHandler(c *gin.Context) {
+	requestStart := time.Now()
+	clientIP := c.ClientIP()
+	userAgent := c.GetHeader("User-Agent")
+	log.Printf("[HTTP REQUEST] OCR extraction request received from client IP: %s, User-Agent: %s, timestamp: %v", clientIP, userAgent, requestStart)
+
+	params, err := parseImageExtractParams(c)
+	if err != nil {
+		log.Printf("[HTTP REQUEST ERROR] Invalid request parameters: %v", err)
+		c.JSON(http.StatusBadRequest, OCRResponse{Success: false, Message: err.Error(), ErrorCode: ErrorCodeInvalidParameter})
+		return
+	}
+	log.Printf("[HTTP REQUEST] Filter type: '%s', detail: '%s'", params.FilterType, params.Detail)
+
+	input, err := readImageInput(c)
+	if err != nil {
+		log.Printf("[HTTP REQUEST ERROR] Failed to read image from request, client IP: %s, error: %v", clientIP, err)
+		c.JSON(inputErrorStatus(err), OCRResponse{Success: false, Message: err.Error(), ErrorCode: inputErrorCode(err)})
+		return
+	}
+
+	log.Printf("[HTTP REQUEST] Image received from %s, size=%d bytes, proceeding with OCR analysis", input.Source, len(input.Data))
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
+	extraction, err := runImageExtraction(ctx, input.Data, params)
+	if err != nil {
+		log.Printf("[HTTP REQUEST ERROR] Image extraction failed for client IP: %s, error: %v", clientIP, err)
+		if abortedByContext(c, err) {
+			return
 		}
+		if retryAfter := retryAfterSeconds(err); retryAfter != "" {
+			c.Header("Retry-After", retryAfter)
+		}
+		c.JSON(extractionErrorStatus(err), OCRResponse{Success: false, Message: extractionErrorMessage(err), ErrorCode: extractionErrorCode(err)})
+		return
 	}
 
 	requestDuration := time.Since(requestStart)
-	response := OCRResponse{Success: true, TextList: finalTexts, TotalCount: len(finalTexts)}
+	finalTexts := extraction.Texts
+	response := OCRResponse{Success: true, TextList: finalTexts, TotalCount: len(finalTexts), Orientation: extraction.Orientation, Layout: extraction.Layout, MenuItems: extraction.MenuItems, Degraded: extraction.Degraded}
 
 	log.Printf("[HTTP REQUEST SUCCESS] OCR extraction completed successfully in %v, client IP: %s, extracted %d text elements", requestDuration, clientIP, len(finalTexts))
 	for i, text := range finalTexts {
@@ -1110,21 +1594,35 @@ func textExtractHandler(c *gin.Context) {
 
 	log.Printf("[HTTP TEXT REQUEST] Processing text: '%s', type: %s", req.Text, extractType)
 
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+	defer cancel()
+
 	var result string
 	var err error
 
 	switch extractType {
 	case "store":
-		result, err = extractStoreNameFromText(req.Text)
+		result, err = extractStoreNameFromText(ctx, req.Text)
 	case "number":
-		result, err = extractNumberFromText(req.Text)
+		result, err = extractNumberFromText(ctx, req.Text)
 	case "food":
-		result, err = extractFoodNameFromText(req.Text)
+		result, err = extractFoodNameFromText(ctx, req.Text)
 	}
 
 	if err != nil {
 		log.Printf("[HTTP TEXT REQUEST ERROR] Text extraction failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, context.Canceled) {
+			c.Abort()
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+			return
+		}
+		if retryAfter := retryAfterSeconds(err); retryAfter != "" {
+			c.Header("Retry-After", retryAfter)
+		}
+		c.JSON(extractionErrorStatus(err), gin.H{"error": err.Error(), "error_code": llmErrorCode(err)})
 		return
 	}
 
@@ -1140,20 +1638,140 @@ func healthHandler(c *gin.Context) {
 	log.Printf("[HTTP HEALTH] Health check request received from client IP: %s", clientIP)
 
 	status := map[string]interface{}{"status": "ok", "ocr": analyzer != nil && analyzer.enabled}
+	if ocrQueue != nil {
+		status["queue"] = ocrQueue.Stats()
+	}
+	if ocrEngineLimiter != nil {
+		status["ocr_processes"] = ocrEngineLimiter.Stats()
+	}
+	if jobRunner != nil {
+		status["pending_jobs"] = jobRunner.Pending()
+	}
+	if llmResponseCache != nil {
+		status["llm_cache"] = llmResponseCache.Stats()
+	}
+	if llmBreaker != nil {
+		breakerStats := llmBreaker.Stats()
+		status["llm_breaker"] = breakerStats
+		if breakerStats["state"] != BreakerClosed {
+			status["status"] = "degraded"
+		}
+	}
 
-	log.Printf("[HTTP HEALTH] Health check response: status=ok, ocr_enabled=%t, client IP: %s", analyzer != nil && analyzer.enabled, clientIP)
+	log.Printf("[HTTP HEALTH] Health check response: status=%s, ocr_enabled=%t, client IP: %s", status["status"], analyzer != nil && analyzer.enabled, clientIP)
 	c.JSON(http.StatusOK, status)
 }
 
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("[CONFIG WARNING] Invalid value %q for %s, using default %d", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("[CONFIG WARNING] Invalid value %q for %s, using default %t", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("[CONFIG WARNING] Invalid value %q for %s, using default %v", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
 func main() {
 	log.Printf("[APPLICATION START] Starting OCR service application initialization at %v", time.Now())
 
-	var err error
-	analyzer, err = NewOCRAnalyzer()
+	engine, err := NewOCREngineFromEnv()
+	if err != nil {
+		log.Fatalf("[APPLICATION START ERROR] OCR engine initialization failed: %v", err)
+	}
+
+	ocrEngineLimiter = NewLimitedOCREngine(engine, getEnvInt("OCR_MAX_PROCESSES", runtime.NumCPU()))
+	ocrQueue = NewAdmissionQueue(
+		getEnvInt("OCR_MAX_ACTIVE_REQUESTS", max(1, runtime.NumCPU()/2)),
+		getEnvInt("OCR_QUEUE_SIZE", 16),
+		time.Duration(getEnvInt("OCR_QUEUE_TIMEOUT_SECONDS", 30))*time.Second,
+	)
+
+	detector, err := NewTextDetectorFromEnv()
+	if err != nil {
+		log.Fatalf("[APPLICATION START ERROR] Text detector initialization failed: %v", err)
+	}
+
+	analyzer, err = NewOCRAnalyzer(ocrEngineLimiter, detector, getEnvInt("OCR_REGION_WORKERS", runtime.NumCPU()))
 	if err != nil {
 		log.Fatalf("[APPLICATION START ERROR] OCR analyzer initialization failed: %v", err)
 	}
 
+	defaultMinConfidence = getEnvFloat("OCR_MIN_CONFIDENCE", 0)
+	loadImageInputConfig()
+	loadUploadLimitsConfig()
+	loadOrientationConfig()
+	loadRegionMergeConfig()
+	if err := loadPreprocessConfig(); err != nil {
+		log.Fatalf("[APPLICATION START ERROR] Preprocessing profile configuration failed: %v", err)
+	}
+	if err := loadEnsembleConfig(); err != nil {
+		log.Fatalf("[APPLICATION START ERROR] Ensemble configuration failed: %v", err)
+	}
+	maxBatchImages = getEnvInt("MAX_BATCH_IMAGES", maxBatchImages)
+	maxBatchArchiveBytes = int64(getEnvInt("MAX_BATCH_ARCHIVE_BYTES", int(maxBatchArchiveBytes)))
+	requestTimeout = time.Duration(getEnvInt("REQUEST_TIMEOUT_SECONDS", int(requestTimeout.Seconds()))) * time.Second
+
+	loadLLMRetryConfig()
+	llmProvider, err = NewLLMProviderFromEnv()
+	if err != nil {
+		log.Fatalf("[APPLICATION START ERROR] LLM provider initialization failed: %v", err)
+	}
+	loadLLMFallbackConfig()
+	model := llmModel(llmProvider)
+	llmBreaker = NewCircuitBreaker(
+		getEnvInt("LLM_BREAKER_FAILURES", 5),
+		time.Duration(getEnvInt("LLM_BREAKER_COOLDOWN_SECONDS", 30))*time.Second,
+	)
+	llmProvider = NewBreakerLLMProvider(llmProvider, llmBreaker)
+
+	cache, err := NewLLMCacheFromEnv()
+	if err != nil {
+		log.Fatalf("[APPLICATION START ERROR] LLM cache initialization failed: %v", err)
+	}
+	if cache != nil {
+		llmResponseCache = NewCachedLLMProvider(llmProvider, cache, model)
+		llmProvider = llmResponseCache
+	}
+
+	loadJobCallbackConfig()
+	jobRunner = NewJobRunner(
+		NewMemoryJobStore(),
+		getEnvInt("JOB_WORKERS", 2),
+		getEnvInt("JOB_QUEUE_SIZE", 100),
+		time.Duration(getEnvInt("JOB_TTL_MINUTES", 60))*time.Minute,
+		time.Duration(getEnvInt("JOB_TIMEOUT_SECONDS", 600))*time.Second,
+	)
+
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
@@ -1161,8 +1779,11 @@ func main() {
 	config.AllowAllOrigins = true
 	r.Use(cors.New(config))
 
-	r.POST("/image/extract", imageExtractHandler)
+	r.POST("/image/extract", bodyLimitMiddleware(maxRequestBytes), admissionMiddleware(ocrQueue), imageExtractHandler)
+	r.POST("/image/extract/batch", bodyLimitMiddleware(maxBatchRequestBytes), batchImageExtractHandler)
 	r.POST("/text/extract", textExtractHandler)
+	r.POST("/jobs/image/extract", bodyLimitMiddleware(maxRequestBytes), createImageJobHandler)
+	r.GET("/jobs/:id", getJobHandler)
 	r.GET("/health", healthHandler)
 
 	port := os.Getenv("PORT")
@@ -1170,12 +1791,16 @@ func main() {
 		port = "8000"
 	}
 
-	log.Printf("[APPLICATION START] OCR service ready to accept requests on port %s, analyzer enabled: %t, tesseract path: %s", port, analyzer.enabled, analyzer.tesseractPath)
+	log.Printf("[APPLICATION START] OCR service ready to accept requests on port %s, analyzer enabled: %t, OCR engine: %s", port, analyzer.enabled, analyzer.engine.Name())
+	log.Printf("[APPLICATION START] LLM provider: %s", llmProvider.Name())
 	log.Printf("[APPLICATION START] Available endpoints:")
 	log.Printf("[APPLICATION START] - POST /image/extract (OCR processing, optional ?type=store or ?type=food)")
+	log.Printf("[APPLICATION START] - POST /image/extract/batch (OCR processing for multiple images or a zip archive)")
 	log.Printf("[APPLICATION START] - POST /text/extract?type=store|number|food (Text processing)")
+	log.Printf("[APPLICATION START] - POST /jobs/image/extract (asynchronous OCR job, same options as /image/extract)")
+	log.Printf("[APPLICATION START] - GET /jobs/:id (OCR job status and result)")
 	log.Printf("[APPLICATION START] - GET /health (service status)")
-	log.Printf("[APPLICATION START] CORS enabled for all origins, request timeout: 15 seconds")
+	log.Printf("[APPLICATION START] CORS enabled for all origins, request timeout: %v, tesseract timeout: 15 seconds", requestTimeout)
 
 	if err := r.Run(":" + port); err != nil {
 		log.Fatalf("[APPLICATION START ERROR] Failed to start HTTP server on port %s: %v", port, err)
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// fixedTextDetector reports the same regions for every image.
+type fixedTextDetector struct {
+	regions []TextRegion
+}
+
+func (d *fixedTextDetector) Name() string {
+	return "fixed"
+}
+
+func (d *fixedTextDetector) Detect(img gocv.Mat) []TextRegion {
+	return d.regions
+}
+
+func whitePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTextsWithFakeEngine(t *testing.T) {
+	if err := loadPreprocessConfig(); err != nil {
+		t.Fatal(err)
+	}
+	line := func(text string, box image.Rectangle, confidence float64) OCRLine {
+		return OCRLine{Text: text, Box: box, Confidence: confidence, Words: []OCRWord{{Text: text, Box: box, Confidence: confidence}}}
+	}
+	fullImage := OCRResult{
+		Text: "맥도날드\n빅맥세트 5,500원\n~~",
+		Lines: []OCRLine{
+			line("맥도날드", image.Rect(10, 10, 110, 40), 92),
+			line("빅맥세트  5,500원", image.Rect(10, 50, 190, 80), 88),
+			line("~~", image.Rect(10, 90, 30, 100), 95),
+			line("흐릿한글씨", image.Rect(10, 100, 90, 110), 20),
+		},
+		Confidence: 74,
+	}
+	regions := []TextRegion{
+		{Box: image.Rect(120, 10, 190, 40)},
+		{Box: image.Rect(10, 10, 110, 40)},
+	}
+
+	tests := []struct {
+		name    string
+		engine  *FakeOCREngine
+		opts    ExtractOptions
+		cancel  bool
+		want    []string
+		sources []string
+		wantErr bool
+	}{
+		{
+			name: "full image lines and regions",
+			engine: &FakeOCREngine{
+				Results: map[string]OCRResult{"3": fullImage, "8": {Text: "콜라", Confidence: 90}},
+				Default: OCRResult{Confidence: -1},
+			},
+			opts:    ExtractOptions{MinConfidence: 50},
+			want:    []string{"맥도날드", "빅맥세트 5,500원", "콜라"},
+			sources: []string{SourceFullImagePSM3, SourceFullImagePSM3, SourceRegionPSM8},
+		},
+		{
+			name: "falls back to psm 6",
+			engine: &FakeOCREngine{
+				Results: map[string]OCRResult{"6": {Text: "아메리카노", Confidence: 80}},
+				Default: OCRResult{Confidence: -1},
+			},
+			want:    []string{"아메리카노"},
+			sources: []string{SourceFullImagePSM6},
+		},
+		{
+			name:   "engine failure leaves no texts",
+			engine: &FakeOCREngine{Err: errors.New("tesseract failed")},
+		},
+		{
+			name:    "cancelled request",
+			engine:  &FakeOCREngine{Default: fullImage},
+			cancel:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyzer, err := NewOCRAnalyzer(tt.engine, &fixedTextDetector{regions: regions}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			result, err := analyzer.ExtractTexts(ctx, whitePNG(t, 200, 120), tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("err = %v, want context.Canceled", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Orientation == nil || result.Orientation.Width != 200 || result.Orientation.Height != 120 {
+				t.Errorf("orientation = %+v, want a 200x120 upright image", result.Orientation)
+			}
+			if len(result.Texts) != len(tt.want) {
+				t.Fatalf("got %d texts %+v, want %v", len(result.Texts), result.Texts, tt.want)
+			}
+			for i, text := range result.Texts {
+				if text.Text != tt.want[i] || text.Source != tt.sources[i] {
+					t.Errorf("text %d = %q from %s, want %q from %s", i, text.Text, text.Source, tt.want[i], tt.sources[i])
+				}
+				if text.BBox == nil {
+					t.Errorf("text %d has no bounding box", i)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractionErrorCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantStatus int
+		wantMsg    string
+	}{
+		{name: "ocr failure", err: &extractionError{Message: "OCR failed", Code: ErrorCodeOCRFailed, Err: errors.New("tesseract failed")}, wantCode: ErrorCodeOCRFailed, wantStatus: http.StatusInternalServerError, wantMsg: "OCR failed"},
+		{name: "bare ocr error", err: errors.New("tesseract failed"), wantCode: ErrorCodeOCRFailed, wantStatus: http.StatusInternalServerError, wantMsg: "OCR failed"},
+		{name: "filter failure", err: &extractionError{Message: "Food name filtering failed", Code: ErrorCodeFilterFailed, Err: errors.New("bad reply")}, wantCode: ErrorCodeFilterFailed, wantStatus: http.StatusInternalServerError, wantMsg: "Food name filtering failed"},
+		{name: "llm failure", err: &extractionError{Message: "Food name filtering failed", Code: ErrorCodeFilterFailed, Err: &LLMError{Kind: LLMErrorUnavailable}}, wantCode: string(LLMErrorUnavailable), wantStatus: http.StatusServiceUnavailable, wantMsg: "Food name filtering failed"},
+		{name: "deadline", err: &extractionError{Message: "OCR failed", Code: ErrorCodeOCRFailed, Err: context.DeadlineExceeded}, wantCode: ErrorCodeTimeout, wantStatus: http.StatusGatewayTimeout, wantMsg: "Request timed out"},
+		{name: "queue full", err: errQueueFull, wantCode: ErrorCodeQueueFull, wantStatus: http.StatusTooManyRequests, wantMsg: errQueueFull.Error()},
+		{name: "queue timeout", err: fmt.Errorf("image 2: %w", errQueueTimeout), wantCode: ErrorCodeQueueTimeout, wantStatus: http.StatusServiceUnavailable, wantMsg: errQueueTimeout.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := extractionErrorCode(tt.err); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if status := extractionErrorStatus(tt.err); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if message := extractionErrorMessage(tt.err); message != tt.wantMsg {
+				t.Errorf("message = %q, want %q", message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestApplyFilterReply(t *testing.T) {
+	texts := []TextElement{
+		{Text: "맥도날드", X: 10, Y: 10},
+		{Text: "비맥세트", X: 10, Y: 40},
+		{Text: "5,500원", X: 80, Y: 40},
+		{Text: "콜라 ", X: 10, Y: 70},
+	}
+
+	type selected struct {
+		index      int
+		text       string
+		corrected  bool
+		confidence float64
+	}
+	tests := []struct {
+		name    string
+		reply   string
+		want    []selected
+		wantErr bool
+	}{
+		{
+			name:  "corrected and unchanged texts",
+			reply: `{"items": [{"original_index": 1, "corrected_text": "빅맥세트", "confidence": 0.92}, {"original_index": 3, "corrected_text": "콜라", "confidence": 0.97}]}`,
+			want:  []selected{{1, "빅맥세트", true, 0.92}, {3, "콜라", false, 0.97}},
+		},
+		{
+			name:  "reply order kept",
+			reply: `{"items": [{"original_index": 3, "corrected_text": "콜라", "confidence": 0.9}, {"original_index": 0, "corrected_text": "맥도날드", "confidence": 0.8}]}`,
+			want:  []selected{{3, "콜라", false, 0.9}, {0, "맥도날드", false, 0.8}},
+		},
+		{
+			name:  "code fence stripped",
+			reply: "```json\n{\"items\": [{\"original_index\": 0, \"corrected_text\": \"맥도날드\", \"confidence\": 1}]}\n```",
+			want:  []selected{{0, "맥도날드", false, 1}},
+		},
+		{
+			name:  "no items",
+			reply: `{"items": []}`,
+			want:  []selected{},
+		},
+		{
+			name: "unusable items dropped",
+			reply: `{"items": [
+				{"original_index": -1, "corrected_text": "a", "confidence": 0.5},
+				{"original_index": 4, "corrected_text": "b", "confidence": 0.5},
+				{"original_index": 1, "corrected_text": "빅맥세트", "confidence": 0.9},
+				{"original_index": 1, "corrected_text": "빅맥", "confidence": 0.9},
+				{"original_index": 2, "corrected_text": "  ", "confidence": 0.9},
+				{"original_index": 3, "corrected_text": "콜라", "confidence": 1.5}
+			]}`,
+			want: []selected{{1, "빅맥세트", true, 0.9}},
+		},
+		{name: "not json", reply: "빅맥세트, 콜라", wantErr: true},
+		{name: "no items list", reply: `{}`, wantErr: true},
+		{name: "unknown field", reply: `{"items": [], "names": ["콜라"]}`, wantErr: true},
+		{name: "wrong index type", reply: `{"items": [{"original_index": "1", "corrected_text": "빅맥세트", "confidence": 0.9}]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyFilterReply(texts, tt.reply)
+			if tt.wantErr {
+				var llmErr *LLMError
+				if !errors.As(err, &llmErr) || llmErr.Kind != LLMErrorBadResponse {
+					t.Fatalf("err = %v, want an %s LLM error", err, LLMErrorBadResponse)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d texts, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				source := texts[want.index]
+				if got[i].Text != want.text || got[i].X != source.X || got[i].Y != source.Y {
+					t.Errorf("text %d = %q at (%d,%d), want %q at (%d,%d)", i, got[i].Text, got[i].X, got[i].Y, want.text, source.X, source.Y)
+				}
+				if got[i].TextCorrection == nil || got[i].Corrected != want.corrected || got[i].OriginalText != strings.TrimSpace(source.Text) {
+					t.Errorf("text %d correction = %+v, want corrected %t", i, got[i].TextCorrection, want.corrected)
+				}
+				if got[i].FilterConfidence != want.confidence {
+					t.Errorf("text %d filter confidence = %v, want %v", i, got[i].FilterConfidence, want.confidence)
+				}
+			}
+		})
+	}
+}
+
+// replyLLMProvider answers every request with the same reply.
+type replyLLMProvider struct {
+	reply string
+}
+
+func (p *replyLLMProvider) Name() string {
+	return "reply"
+}
+
+func (p *replyLLMProvider) Complete(ctx context.Context, req LLMRequest) (string, error) {
+	return p.reply, nil
+}
+
+func TestRunImageExtractionCorrectionFields(t *testing.T) {
+	if err := loadPreprocessConfig(); err != nil {
+		t.Fatal(err)
+	}
+	engine := &FakeOCREngine{
+		Results: map[string]OCRResult{"3": {
+			Text:       "맥도날드\n비맥세트",
+			Lines:      []OCRLine{{Text: "맥도날드", Box: image.Rect(10, 10, 110, 40), Confidence: 92}, {Text: "비맥세트", Box: image.Rect(10, 50, 110, 80), Confidence: 71}},
+			Confidence: 80,
+		}},
+		Default: OCRResult{Confidence: -1},
+	}
+	testAnalyzer, err := NewOCRAnalyzer(engine, &fixedTextDetector{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(a *OCRAnalyzer, p LLMProvider) { analyzer, llmProvider = a, p }(analyzer, llmProvider)
+	analyzer = testAnalyzer
+	llmProvider = &replyLLMProvider{reply: `{"items": [{"original_index": 1, "corrected_text": "빅맥세트", "confidence": 0.92}]}`}
+
+	tests := []struct {
+		name       string
+		filterType string
+		detail     string
+		want       []string
+		absent     []string
+	}{
+		{name: "filtered basic", filterType: "food", detail: "basic", want: []string{`"original_text":"비맥세트"`, `"corrected":true`, `"filter_confidence":0.92`}, absent: []string{`"source"`}},
+		{name: "filtered full", filterType: "food", detail: "full", want: []string{`"original_text":"비맥세트"`, `"filter_confidence":0.92`, `"source"`}},
+		{name: "unfiltered basic", detail: "basic", absent: []string{`"original_text"`, `"filter_confidence"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extraction, err := runImageExtraction(context.Background(), whitePNG(t, 200, 120), ImageExtractParams{FilterType: tt.filterType, Detail: tt.detail, Layout: LayoutFlat})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, err := json.Marshal(OCRResponse{Success: true, TextList: extraction.Texts, TotalCount: len(extraction.Texts)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range tt.want {
+				if !strings.Contains(string(body), field) {
+					t.Errorf("response %s has no %s", body, field)
+				}
+			}
+			for _, field := range tt.absent {
+				if strings.Contains(string(body), field) {
+					t.Errorf("response %s has %s", body, field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"image"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const CurrencyKRW = "KRW"
+
+// MenuItem is a dish paired with the price printed next to or below it.
+// Price is null when no price could be paired with the dish. BBox covers
+// both the name and the price.
+type MenuItem struct {
+	Name     string       `json:"name"`
+	Price    *int         `json:"price"`
+	Currency string       `json:"currency,omitempty"`
+	BBox     *BoundingBox `json:"bbox,omitempty"`
+}
+
+// wonDigits matches an amount in won, with or without thousands separators.
+// Both "5,500" and "5.500" are used as separators, so a dot followed by
+// exactly three digits is read as one.
+const wonDigits = `\d{1,3}(?:,\d{3})+|\d{1,3}(?:\.\d{3})+|\d+`
+
+var (
+	// pricePattern matches a price written with a currency marker, such as
+	// "5,500원", "35.000원", "5.5천원", "1만 2천원" or "₩5500".
+	pricePattern = regexp.MustCompile(`(?:₩|￦|\\)\s*(` + wonDigits + `)|(?:(\d+(?:\.\d+)?)\s*만\s*)?(?:(\d+(?:\.\d+)?)\s*천\s*)?(` + wonDigits + `)?\s*원`)
+	// barePricePattern matches a text that is only a number with thousands
+	// separators, as in menus that print "5,500" or "5.500", or the
+	// shorthand "5.5" for 5,500원. Plain numbers such as "2024" or "1588"
+	// are years, phone prefixes and menu numbers more often than prices.
+	barePricePattern = regexp.MustCompile(`^(?:(\d{1,3}(?:,\d{3})+|\d{1,3}(?:\.\d{3})+)|(\d{1,2}\.\d))$`)
+)
+
+// minMenuPrice keeps stray digits such as menu numbers from being read as
+// prices.
+const minMenuPrice = 100
+
+// parsePrice finds a Korean won price in text and returns it in won along
+// with the byte range of the price in text.
+func parsePrice(text string) (int, []int, bool) {
+	trimmed := strings.TrimSpace(text)
+	if match := barePricePattern.FindStringSubmatch(trimmed); match != nil {
+		price := wonAmount(match[1])
+		if match[2] != "" {
+			// Menus often abbreviate thousands of won as "5.5".
+			value, _ := strconv.ParseFloat(match[2], 64)
+			price = value * 1000
+		}
+		start := strings.Index(text, trimmed)
+		return validPrice(price, []int{start, start + len(trimmed)})
+	}
+
+	for _, match := range pricePattern.FindAllStringSubmatchIndex(text, -1) {
+		group := func(i int) string {
+			if match[2*i] < 0 {
+				return ""
+			}
+			return text[match[2*i]:match[2*i+1]]
+		}
+		number := func(value string) float64 {
+			parsed, _ := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+			return parsed
+		}
+
+		if won := group(1); won != "" {
+			if price, span, ok := validPrice(wonAmount(won), match[:2]); ok {
+				return price, span, true
+			}
+			continue
+		}
+
+		man, cheon, won := group(2), group(3), group(4)
+		if man == "" && cheon == "" && won == "" {
+			continue
+		}
+		price := number(man)*10000 + number(cheon)*1000 + wonAmount(won)
+		if price, span, ok := validPrice(price, match[:2]); ok {
+			return price, span, true
+		}
+	}
+	return 0, nil, false
+}
+
+// wonAmount reads a number matched by wonDigits.
+func wonAmount(value string) float64 {
+	parsed, _ := strconv.Atoi(strings.NewReplacer(",", "", ".", "").Replace(value))
+	return float64(parsed)
+}
+
+func validPrice(price float64, span []int) (int, []int, bool) {
+	rounded := int(math.Round(price))
+	if rounded < minMenuPrice {
+		return 0, nil, false
+	}
+	return rounded, span, true
+}
+
+type menuPrice struct {
+	value int
+	box   image.Rectangle
+	used  bool
+}
+
+type menuPairing struct {
+	dish, price int
+	cost        float64
+}
+
+const (
+	// menuColumnGap is how far below a dish, in dish heights, a price may
+	// sit and still belong to it.
+	menuColumnGap = 2.5
+	// menuColumnPenalty makes a price on the same line win over one below.
+	menuColumnPenalty = 0.5
+)
+
+// pairMenuPrices pairs each dish with the nearest unused price that sits on
+// the same line to its right or in the same column just below it. Prices
+// are read from every text in the image, since the food filter drops them;
+// a text holding both a name and a price pairs with itself. Pairs are taken
+// cheapest first so each price goes to at most one dish.
+func pairMenuPrices(dishes []TextElement, texts []TextElement) []MenuItem {
+	var prices []menuPrice
+	for _, text := range texts {
+		if text.BBox == nil {
+			continue
+		}
+		if value, _, ok := parsePrice(text.Text); ok {
+			prices = append(prices, menuPrice{value: value, box: boundingBoxRect(*text.BBox)})
+		}
+	}
+
+	var pairings []menuPairing
+	for i, dish := range dishes {
+		if dish.BBox == nil {
+			continue
+		}
+		dishBox := boundingBoxRect(*dish.BBox)
+		for j, price := range prices {
+			if cost, ok := menuPairCost(dishBox, price.box); ok {
+				pairings = append(pairings, menuPairing{dish: i, price: j, cost: cost})
+			}
+		}
+	}
+	sort.SliceStable(pairings, func(i, j int) bool { return pairings[i].cost < pairings[j].cost })
+
+	items := make([]MenuItem, len(dishes))
+	paired := make([]bool, len(dishes))
+	for i, dish := range dishes {
+		items[i] = MenuItem{Name: menuItemName(dish.Text), BBox: dish.BBox}
+	}
+	for _, pairing := range pairings {
+		if paired[pairing.dish] || prices[pairing.price].used {
+			continue
+		}
+		price := &prices[pairing.price]
+		price.used = true
+		paired[pairing.dish] = true
+
+		value := price.value
+		item := &items[pairing.dish]
+		item.Price = &value
+		item.Currency = CurrencyKRW
+		box := toBoundingBox(boundingBoxRect(*item.BBox).Union(price.box))
+		item.BBox = &box
+	}
+
+	pairedCount := 0
+	for _, ok := range paired {
+		if ok {
+			pairedCount++
+		}
+	}
+	log.Printf("[MENU PAIRING] Paired %d of %d dishes with %d prices found", pairedCount, len(dishes), len(prices))
+	return items
+}
+
+// menuPairCost scores how well a price box fits a dish box, in dish heights
+// of distance. It reports false when the price is neither on the same line
+// to the right of the dish nor in the same column just below it.
+func menuPairCost(dish, price image.Rectangle) (float64, bool) {
+	height := float64(max(1, dish.Dy()))
+
+	shorter := min(dish.Dy(), price.Dy())
+	verticalOverlap := min(dish.Max.Y, price.Max.Y) - max(dish.Min.Y, price.Min.Y)
+	if shorter > 0 && verticalOverlap*2 >= shorter && price.Max.X > dish.Min.X {
+		return float64(max(0, price.Min.X-dish.Max.X)) / height, true
+	}
+
+	horizontalOverlap := min(dish.Max.X, price.Max.X) - max(dish.Min.X, price.Min.X)
+	gap := float64(price.Min.Y - dish.Max.Y)
+	if horizontalOverlap > 0 && price.Min.Y >= dish.Min.Y+dish.Dy()/2 && gap <= menuColumnGap*height {
+		return max(0, gap)/height + menuColumnPenalty, true
+	}
+	return 0, false
+}
+
+// menuItemName drops a price that was read together with the dish name.
+func menuItemName(text string) string {
+	if _, span, ok := parsePrice(text); ok {
+		if name := strings.TrimSpace(text[:span[0]] + " " + text[span[1]:]); name != "" {
+			text = name
+		}
+	}
+	return strings.Join(strings.Fields(strings.Trim(text, " .·…-")), " ")
+}
+
+func boundingBoxRect(box BoundingBox) image.Rectangle {
+	return image.Rect(box.X, box.Y, box.X+box.Width, box.Y+box.Height)
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		text  string
+		price int
+		span  []int
+		ok    bool
+	}{
+		{text: "5,500원", price: 5500, span: []int{0, 8}, ok: true},
+		{text: "35.000원", price: 35000, span: []int{0, 9}, ok: true},
+		{text: "10.000 원", price: 10000, span: []int{0, 10}, ok: true},
+		{text: "5.5천원", price: 5500, span: []int{0, 9}, ok: true},
+		{text: "1만 2천원", price: 12000, span: []int{0, 12}, ok: true},
+		{text: "3만원", price: 30000, span: []int{0, 7}, ok: true},
+		{text: "₩5500", price: 5500, span: []int{0, 7}, ok: true},
+		{text: "₩35.000", price: 35000, span: []int{0, 9}, ok: true},
+		{text: "5,500", price: 5500, span: []int{0, 5}, ok: true},
+		{text: " 5.500 ", price: 5500, span: []int{1, 6}, ok: true},
+		{text: "12,000", price: 12000, span: []int{0, 6}, ok: true},
+		{text: "5.5", price: 5500, span: []int{0, 3}, ok: true},
+		{text: "빅맥세트 5,500원", price: 5500, span: []int{13, 21}, ok: true},
+		{text: "5500"},
+		{text: "2024"},
+		{text: "1588"},
+		{text: "1588-1234"},
+		{text: "50원"},
+		{text: "빅맥세트"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			price, span, ok := parsePrice(tt.text)
+			if ok != tt.ok || price != tt.price {
+				t.Fatalf("parsePrice(%q) = %d, %t, want %d, %t", tt.text, price, ok, tt.price, tt.ok)
+			}
+			if ok && (span[0] != tt.span[0] || span[1] != tt.span[1]) {
+				t.Errorf("span = %v, want %v", span, tt.span)
+			}
+		})
+	}
+}
+
+func TestPairMenuPrices(t *testing.T) {
+	box := func(x, y, width, height int) *BoundingBox {
+		return &BoundingBox{X: x, Y: y, Width: width, Height: height}
+	}
+	dishes := []TextElement{
+		{Text: "빅맥세트", BBox: box(0, 0, 100, 20)},
+		{Text: "치즈버거", BBox: box(0, 50, 100, 20)},
+		{Text: "콜라 2,000원", BBox: box(0, 100, 150, 20)},
+		{Text: "감자튀김", BBox: box(400, 200, 100, 20)},
+	}
+	texts := append([]TextElement{
+		{Text: "5,500원", BBox: box(150, 0, 60, 20)},
+		{Text: "4.5", BBox: box(10, 72, 40, 20)},
+		{Text: "2024", BBox: box(150, 50, 40, 20)},
+	}, dishes...)
+
+	items := pairMenuPrices(dishes, texts)
+	want := []struct {
+		name  string
+		price int
+	}{
+		{"빅맥세트", 5500},
+		{"치즈버거", 4500},
+		{"콜라", 2000},
+		{"감자튀김", 0},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for i, item := range items {
+		if item.Name != want[i].name {
+			t.Errorf("item %d name = %q, want %q", i, item.Name, want[i].name)
+		}
+		switch {
+		case want[i].price == 0 && item.Price != nil:
+			t.Errorf("item %d price = %d, want none", i, *item.Price)
+		case want[i].price != 0 && (item.Price == nil || *item.Price != want[i].price):
+			t.Errorf("item %d price = %v, want %d", i, item.Price, want[i].price)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+type OCROptions struct {
+	PSM       string
+	Languages string
+}
+
+type OCRWord struct {
+	Text       string
+	Box        image.Rectangle
+	Confidence float64
+}
+
+type OCRLine struct {
+	Text       string
+	Box        image.Rectangle
+	Confidence float64
+	Words      []OCRWord
+}
+
+// OCRResult is what an engine read from one image. Confidence is -1 when the
+// engine does not report it. Boxes are in the pixel space of the image that
+// was passed to the engine.
+type OCRResult struct {
+	Text       string
+	Lines      []OCRLine
+	Words      []OCRWord
+	Confidence float64
+}
+
+type OCREngine interface {
+	Name() string
+	Recognize(ctx context.Context, img gocv.Mat, opts OCROptions) (OCRResult, error)
+}
+
+// OrientationResult is the clockwise rotation, in multiples of 90 degrees,
+// that turns a page upright.
+type OrientationResult struct {
+	Rotate     int
+	Confidence float64
+}
+
+// OrientationDetector is implemented by engines that can detect page
+// orientation in addition to reading text.
+type OrientationDetector interface {
+	DetectOrientation(ctx context.Context, img gocv.Mat) (OrientationResult, error)
+}
+
+var errOrientationUnsupported = fmt.Errorf("OCR engine does not support orientation detection")
+
+type TesseractCLIEngine struct {
+	tesseractPath string
+	tessDataPath  string
+	timeout       time.Duration
+}
+
+func NewTesseractCLIEngine(tesseractPath, tessDataPath string) (*TesseractCLIEngine, error) {
+	if _, err := os.Stat(tesseractPath); err != nil {
+		log.Printf("[OCR ENGINE ERROR] Tesseract binary not found at expected path %s, system check failed with error: %v", tesseractPath, err)
+		return nil, fmt.Errorf("tesseract not found")
+	}
+
+	os.Setenv("TESSDATA_PREFIX", tessDataPath)
+	log.Printf("[OCR ENGINE] Tesseract CLI engine ready, binary: %s, TESSDATA_PREFIX: %s", tesseractPath, tessDataPath)
+	return &TesseractCLIEngine{tesseractPath: tesseractPath, tessDataPath: tessDataPath, timeout: 15 * time.Second}, nil
+}
+
+func (t *TesseractCLIEngine) Name() string {
+	return "tesseract-cli:" + t.tesseractPath
+}
+
+func (t *TesseractCLIEngine) Recognize(ctx context.Context, img gocv.Mat, opts OCROptions) (OCRResult, error) {
+	encoded, err := gocv.IMEncode(gocv.PNGFileExt, img)
+	if err != nil {
+		log.Printf("[OCR TESSERACT] Failed to encode image of size %dx%d as PNG: %v", img.Cols(), img.Rows(), err)
+		return OCRResult{Confidence: -1}, fmt.Errorf("failed to encode image: %w", err)
+	}
+	defer encoded.Close()
+
+	languages := opts.Languages
+	if languages == "" {
+		languages = "kor+eng"
+	}
+	log.Printf("[OCR TESSERACT] Executing tesseract with %d byte PNG on stdin, PSM: %s, languages: %s", len(encoded.GetBytes()), opts.PSM, languages)
+
+	output, err := t.run(ctx, encoded.GetBytes(), "-l", languages, "--psm", opts.PSM, "tsv")
+	if err != nil {
+		return OCRResult{Confidence: -1}, err
+	}
+	return parseTesseractTSV(output), nil
+}
+
+// DetectOrientation runs tesseract orientation and script detection
+// (--psm 0), which needs osd.traineddata in the tessdata directory.
+func (t *TesseractCLIEngine) DetectOrientation(ctx context.Context, img gocv.Mat) (OrientationResult, error) {
+	encoded, err := gocv.IMEncode(gocv.PNGFileExt, img)
+	if err != nil {
+		return OrientationResult{}, fmt.Errorf("failed to encode image: %w", err)
+	}
+	defer encoded.Close()
+
+	log.Printf("[OCR TESSERACT] Executing tesseract orientation detection with %d byte PNG on stdin", len(encoded.GetBytes()))
+	output, err := t.run(ctx, encoded.GetBytes(), "--psm", "0")
+	if err != nil {
+		return OrientationResult{}, err
+	}
+	return parseTesseractOSD(output)
+}
+
+// run pipes a PNG-encoded image to tesseract over stdin and returns what it
+// writes to stdout, so no image ever touches the disk.
+func (t *TesseractCLIEngine) run(ctx context.Context, pngData []byte, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, t.tesseractPath, append([]string{"stdin", "stdout"}, args...)...)
+	cmd.Env = append(os.Environ(), "TESSDATA_PREFIX="+t.tessDataPath)
+	cmd.Stdin = bytes.NewReader(pngData)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	startTime := time.Now()
+	output, err := cmd.Output()
+	duration := time.Since(startTime)
+
+	if err != nil {
+		log.Printf("[OCR TESSERACT] Tesseract execution failed after %v with error: %v, stderr: %s", duration, err, stderr.String())
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("tesseract aborted: %w", ctx.Err())
+		}
+		return "", fmt.Errorf("tesseract failed: %w", err)
+	}
+
+	log.Printf("[OCR TESSERACT] Tesseract execution completed successfully in %v, output length: %d bytes", duration, len(output))
+	return string(output), nil
+}
+
+// parseTesseractOSD reads the "Rotate" and "Orientation confidence" fields
+// from tesseract's --psm 0 report.
+func parseTesseractOSD(output string) (OrientationResult, error) {
+	result := OrientationResult{}
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Rotate":
+			rotate, err := strconv.Atoi(value)
+			if err != nil {
+				return result, fmt.Errorf("invalid OSD rotation %q", value)
+			}
+			result.Rotate = rotate
+			found = true
+		case "Orientation confidence":
+			confidence, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				result.Confidence = confidence
+			}
+		}
+	}
+	if !found {
+		return result, fmt.Errorf("tesseract OSD output has no rotation")
+	}
+	return result, nil
+}
+
+// parseTesseractTSV turns tesseract's TSV output into words grouped by the
+// block/paragraph/line they belong to. Only level 5 rows carry word text and
+// confidence; line boxes are the union of their word boxes.
+func parseTesseractTSV(tsv string) OCRResult {
+	result := OCRResult{Confidence: -1}
+	lineIndex := make(map[string]int)
+	var confidenceSum float64
+
+	for i, row := range strings.Split(tsv, "\n") {
+		fields := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if i == 0 || len(fields) < 12 || fields[0] != "5" {
+			continue
+		}
+
+		text := strings.TrimSpace(strings.Join(fields[11:], "\t"))
+		if text == "" {
+			continue
+		}
+
+		values := make([]int, 4)
+		valid := true
+		for j := range values {
+			value, err := strconv.Atoi(fields[6+j])
+			if err != nil {
+				valid = false
+				break
+			}
+			values[j] = value
+		}
+		confidence, err := strconv.ParseFloat(fields[10], 64)
+		if !valid || err != nil {
+			log.Printf("[OCR TSV PARSE] Skipping malformed TSV row %d: %q", i+1, row)
+			continue
+		}
+
+		word := OCRWord{
+			Text:       text,
+			Box:        image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3]),
+			Confidence: confidence,
+		}
+		result.Words = append(result.Words, word)
+		confidenceSum += confidence
+
+		key := strings.Join(fields[1:5], "/")
+		idx, ok := lineIndex[key]
+		if !ok {
+			idx = len(result.Lines)
+			lineIndex[key] = idx
+			result.Lines = append(result.Lines, OCRLine{Box: word.Box})
+		}
+		line := &result.Lines[idx]
+		line.Words = append(line.Words, word)
+		line.Box = line.Box.Union(word.Box)
+	}
+
+	lineTexts := make([]string, 0, len(result.Lines))
+	for i := range result.Lines {
+		line := &result.Lines[i]
+		words := make([]string, len(line.Words))
+		var sum float64
+		for j, word := range line.Words {
+			words[j] = word.Text
+			sum += word.Confidence
+		}
+		line.Text = strings.Join(words, " ")
+		line.Confidence = sum / float64(len(line.Words))
+		lineTexts = append(lineTexts, line.Text)
+	}
+
+	result.Text = strings.Join(lineTexts, "\n")
+	if len(result.Words) > 0 {
+		result.Confidence = confidenceSum / float64(len(result.Words))
+	}
+	return result
+}
+
+// FakeOCREngine returns canned results keyed by page segmentation mode. It is
+// meant for exercising the pipeline without tesseract installed.
+type FakeOCREngine struct {
+	Results map[string]OCRResult
+	Default OCRResult
+	Err     error
+}
+
+func (f *FakeOCREngine) Name() string {
+	return "fake"
+}
+
+func (f *FakeOCREngine) Recognize(ctx context.Context, img gocv.Mat, opts OCROptions) (OCRResult, error) {
+	if err := ctx.Err(); err != nil {
+		return OCRResult{Confidence: -1}, err
+	}
+	if f.Err != nil {
+		return OCRResult{Confidence: -1}, f.Err
+	}
+	result, ok := f.Results[opts.PSM]
+	if !ok {
+		result = f.Default
+	}
+	if len(result.Lines) == 0 && result.Text != "" {
+		result.Lines = fakeLines(result.Text, result.Confidence, img.Cols(), img.Rows())
+	}
+	return result, nil
+}
+
+// fakeLines stacks the lines of a canned text evenly down the image so the
+// fake engine still produces plausible boxes.
+func fakeLines(text string, confidence float64, width, height int) []OCRLine {
+	var texts []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			texts = append(texts, line)
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+
+	lineHeight := max(1, height/len(texts))
+	lines := make([]OCRLine, len(texts))
+	for i, line := range texts {
+		box := image.Rect(0, i*lineHeight, width, (i+1)*lineHeight)
+		lines[i] = OCRLine{
+			Text:       line,
+			Box:        box,
+			Confidence: confidence,
+			Words:      []OCRWord{{Text: line, Box: box, Confidence: confidence}},
+		}
+	}
+	return lines
+}
+
+func NewOCREngineFromEnv() (OCREngine, error) {
+	switch engineName := os.Getenv("OCR_ENGINE"); engineName {
+	case "", "tesseract":
+		tesseractPath := os.Getenv("TESSERACT_PATH")
+		if tesseractPath == "" {
+			tesseractPath = "/usr/bin/tesseract"
+		}
+		tessDataPath := os.Getenv("TESSDATA_PREFIX")
+		if tessDataPath == "" {
+			tessDataPath = "/usr/share/tesseract-ocr/4.00/tessdata"
+		}
+		engine, err := NewTesseractCLIEngine(tesseractPath, tessDataPath)
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+	case "fake":
+		text := os.Getenv("OCR_FAKE_TEXT")
+		return &FakeOCREngine{Default: OCRResult{Text: text, Confidence: 100}}, nil
+	default:
+		return nil, fmt.Errorf("unknown OCR engine %q", engineName)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+const tsvHeader = "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext"
+
+func tsvRows(rows ...string) string {
+	return strings.Join(append([]string{tsvHeader}, rows...), "\n")
+}
+
+func TestParseTesseractTSV(t *testing.T) {
+	tests := []struct {
+		name       string
+		tsv        string
+		text       string
+		lines      []string
+		lineBoxes  []image.Rectangle
+		words      int
+		confidence float64
+	}{
+		{
+			name:       "empty output",
+			tsv:        tsvHeader,
+			confidence: -1,
+		},
+		{
+			name: "words grouped into lines",
+			tsv: tsvRows(
+				"1\t1\t0\t0\t0\t0\t0\t0\t300\t100\t-1\t",
+				"4\t1\t1\t1\t1\t0\t10\t10\t200\t30\t-1\t",
+				"5\t1\t1\t1\t1\t1\t10\t10\t100\t30\t90\t빅맥세트",
+				"5\t1\t1\t1\t1\t2\t120\t12\t90\t28\t80\t5,500원",
+				"5\t1\t1\t1\t2\t1\t10\t50\t60\t30\t70\t콜라",
+			),
+			text:       "빅맥세트 5,500원\n콜라",
+			lines:      []string{"빅맥세트 5,500원", "콜라"},
+			lineBoxes:  []image.Rectangle{image.Rect(10, 10, 210, 40), image.Rect(10, 50, 70, 80)},
+			words:      3,
+			confidence: 80,
+		},
+		{
+			name: "same line number in another block is another line",
+			tsv: tsvRows(
+				"5\t1\t1\t1\t1\t1\t0\t0\t10\t10\t50\tA",
+				"5\t1\t2\t1\t1\t1\t0\t20\t10\t10\t70\tB",
+			),
+			text:       "A\nB",
+			lines:      []string{"A", "B"},
+			lineBoxes:  []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(0, 20, 10, 30)},
+			words:      2,
+			confidence: 60,
+		},
+		{
+			name: "blank and malformed rows skipped",
+			tsv: tsvRows(
+				"5\t1\t1\t1\t1\t1\t0\t0\t10\t10\t95\t ",
+				"5\t1\t1\t1\t1\t2\tx\t0\t10\t10\t95\tbad",
+				"5\t1\t1\t1\t1\t3\t0\t0\t10\t10\tnan?\tbad",
+				"5\t1\t1\t1\t1\t4\t0\t0\t10\t10\t40\tok\r",
+			),
+			text:       "ok",
+			lines:      []string{"ok"},
+			lineBoxes:  []image.Rectangle{image.Rect(0, 0, 10, 10)},
+			words:      1,
+			confidence: 40,
+		},
+		{
+			name:       "tab inside text kept",
+			tsv:        tsvRows("5\t1\t1\t1\t1\t1\t0\t0\t10\t10\t50\ta\tb"),
+			text:       "a\tb",
+			lines:      []string{"a\tb"},
+			lineBoxes:  []image.Rectangle{image.Rect(0, 0, 10, 10)},
+			words:      1,
+			confidence: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseTesseractTSV(tt.tsv)
+			if result.Text != tt.text {
+				t.Errorf("Text = %q, want %q", result.Text, tt.text)
+			}
+			if result.Confidence != tt.confidence {
+				t.Errorf("Confidence = %v, want %v", result.Confidence, tt.confidence)
+			}
+			if len(result.Words) != tt.words {
+				t.Errorf("got %d words, want %d", len(result.Words), tt.words)
+			}
+			if len(result.Lines) != len(tt.lines) {
+				t.Fatalf("got %d lines, want %d", len(result.Lines), len(tt.lines))
+			}
+			for i, line := range result.Lines {
+				if line.Text != tt.lines[i] {
+					t.Errorf("line %d text = %q, want %q", i, line.Text, tt.lines[i])
+				}
+				if line.Box != tt.lineBoxes[i] {
+					t.Errorf("line %d box = %v, want %v", i, line.Box, tt.lineBoxes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseTesseractOSD(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    OrientationResult
+		wantErr bool
+	}{
+		{
+			name: "upside down",
+			output: "Page number: 0\nOrientation in degrees: 180\nRotate: 180\n" +
+				"Orientation confidence: 12.34\nScript: Hangul\nScript confidence: 3.10\n",
+			want: OrientationResult{Rotate: 180, Confidence: 12.34},
+		},
+		{
+			name:   "missing confidence",
+			output: "Rotate: 90\n",
+			want:   OrientationResult{Rotate: 90},
+		},
+		{
+			name:   "invalid confidence ignored",
+			output: "Rotate: 270\nOrientation confidence: high\n",
+			want:   OrientationResult{Rotate: 270},
+		},
+		{
+			name:    "no rotation",
+			output:  "Too few characters. Skipping this page\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid rotation",
+			output:  "Rotate: ninety\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTesseractOSD(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
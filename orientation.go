@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// ImageOrientation describes how a photo was turned upright before OCR.
+// Returned coordinates are in the upright image; Transform maps them back to
+// the original photo as x' = t[0]*x + t[1]*y + t[2], y' = t[3]*x + t[4]*y + t[5].
+type ImageOrientation struct {
+	EXIFOrientation int        `json:"exif_orientation"`
+	Rotation        int        `json:"rotation"`
+	Skew            float64    `json:"skew"`
+	Method          string     `json:"method,omitempty"`
+	OriginalWidth   int        `json:"original_width"`
+	OriginalHeight  int        `json:"original_height"`
+	Width           int        `json:"width"`
+	Height          int        `json:"height"`
+	Transform       [6]float64 `json:"transform"`
+}
+
+const (
+	OrientationMethodOSD   = "osd"
+	OrientationMethodHough = "hough"
+)
+
+var (
+	// autoRotateDefault is on since photos are often sideways or skewed.
+	// Coordinates are then in the corrected image; the response reports the
+	// rotation applied so clients can map them back.
+	autoRotateDefault        = true
+	osdMinConfidence         = 2.0
+	maxSkewDegrees           = 15.0
+	minSkewDegrees           = 0.5
+	minSkewLines             = 5
+	orientationWhiteBackdrop = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+func loadOrientationConfig() {
+	autoRotateDefault = getEnvBool("OCR_AUTO_ROTATE", autoRotateDefault)
+	osdMinConfidence = getEnvFloat("OCR_OSD_MIN_CONFIDENCE", osdMinConfidence)
+	maxSkewDegrees = getEnvFloat("OCR_MAX_SKEW_DEGREES", maxSkewDegrees)
+	log.Printf("[ORIENTATION CONFIG] Auto rotate: %t, OSD min confidence: %.1f, max skew: %.1f degrees", autoRotateDefault, osdMinConfidence, maxSkewDegrees)
+}
+
+// affine maps (x, y) to (a*x + b*y + c, d*x + e*y + f).
+type affine [6]float64
+
+func identityAffine() affine {
+	return affine{1, 0, 0, 0, 1, 0}
+}
+
+// then returns the transform that applies m first and next second.
+func (m affine) then(next affine) affine {
+	return affine{
+		next[0]*m[0] + next[1]*m[3], next[0]*m[1] + next[1]*m[4], next[0]*m[2] + next[1]*m[5] + next[2],
+		next[3]*m[0] + next[4]*m[3], next[3]*m[1] + next[4]*m[4], next[3]*m[2] + next[4]*m[5] + next[5],
+	}
+}
+
+func (m affine) invert() affine {
+	det := m[0]*m[4] - m[1]*m[3]
+	a, b := m[4]/det, -m[1]/det
+	d, e := -m[3]/det, m[0]/det
+	return affine{a, b, -(a*m[2] + b*m[5]), d, e, -(d*m[2] + e*m[5])}
+}
+
+// orientImage turns img upright in place. EXIF orientation is always
+// honored; with autoRotate the engine's orientation detection and a Hough
+// line skew estimate are applied on top of it.
+func (ocr *OCRAnalyzer) orientImage(ctx context.Context, img *gocv.Mat, imageData []byte, autoRotate bool) *ImageOrientation {
+	orientation := &ImageOrientation{EXIFOrientation: 1, OriginalWidth: img.Cols(), OriginalHeight: img.Rows()}
+	forward := identityAffine()
+
+	if detectImageFormat(imageData) == "jpeg" {
+		orientation.EXIFOrientation = jpegEXIFOrientation(imageData)
+	}
+	if orientation.EXIFOrientation != 1 {
+		forward = forward.then(applyEXIFOrientation(img, orientation.EXIFOrientation))
+		log.Printf("[OCR ORIENTATION] Applied EXIF orientation %d, image is now %dx%d", orientation.EXIFOrientation, img.Cols(), img.Rows())
+	}
+
+	if autoRotate {
+		if detector, ok := ocr.engine.(OrientationDetector); ok {
+			result, err := detector.DetectOrientation(ctx, *img)
+			switch {
+			case err != nil:
+				log.Printf("[OCR ORIENTATION] Orientation detection unavailable: %v", err)
+			case result.Confidence < osdMinConfidence:
+				log.Printf("[OCR ORIENTATION] Ignoring OSD rotation %d with confidence %.2f below %.2f", result.Rotate, result.Confidence, osdMinConfidence)
+			case result.Rotate%360 != 0:
+				orientation.Rotation = ((result.Rotate % 360) + 360) % 360
+				orientation.Method = OrientationMethodOSD
+				forward = forward.then(rotateRightAngle(img, orientation.Rotation))
+				log.Printf("[OCR ORIENTATION] Rotated image %d degrees clockwise from OSD, confidence %.2f", orientation.Rotation, result.Confidence)
+			}
+		}
+
+		if skew, ok := estimateSkew(*img); ok {
+			orientation.Skew = skew
+			if orientation.Method != "" {
+				orientation.Method += "+" + OrientationMethodHough
+			} else {
+				orientation.Method = OrientationMethodHough
+			}
+			forward = forward.then(deskewImage(img, skew))
+			log.Printf("[OCR ORIENTATION] Deskewed image by %.2f degrees, image is now %dx%d", skew, img.Cols(), img.Rows())
+		}
+	}
+
+	orientation.Width, orientation.Height = img.Cols(), img.Rows()
+	orientation.Transform = forward.invert()
+	return orientation
+}
+
+// replaceMat releases img and makes it refer to next.
+func replaceMat(img *gocv.Mat, next gocv.Mat) {
+	img.Close()
+	*img = next
+}
+
+// applyEXIFOrientation undoes the camera orientation recorded in EXIF and
+// returns the transform from original to upright coordinates.
+func applyEXIFOrientation(img *gocv.Mat, orientation int) affine {
+	w, h := float64(img.Cols()), float64(img.Rows())
+	dst := gocv.NewMat()
+
+	var forward affine
+	switch orientation {
+	case 2:
+		gocv.Flip(*img, &dst, 1)
+		forward = affine{-1, 0, w, 0, 1, 0}
+	case 3:
+		gocv.Rotate(*img, &dst, gocv.Rotate180Clockwise)
+		forward = affine{-1, 0, w, 0, -1, h}
+	case 4:
+		gocv.Flip(*img, &dst, 0)
+		forward = affine{1, 0, 0, 0, -1, h}
+	case 5:
+		gocv.Transpose(*img, &dst)
+		forward = affine{0, 1, 0, 1, 0, 0}
+	case 6:
+		gocv.Rotate(*img, &dst, gocv.Rotate90Clockwise)
+		forward = affine{0, -1, h, 1, 0, 0}
+	case 7:
+		transposed := gocv.NewMat()
+		gocv.Transpose(*img, &transposed)
+		gocv.Flip(transposed, &dst, -1)
+		transposed.Close()
+		forward = affine{0, -1, h, -1, 0, w}
+	case 8:
+		gocv.Rotate(*img, &dst, gocv.Rotate90CounterClockwise)
+		forward = affine{0, 1, 0, -1, 0, w}
+	default:
+		dst.Close()
+		return identityAffine()
+	}
+
+	replaceMat(img, dst)
+	return forward
+}
+
+// rotateRightAngle rotates img clockwise by 90, 180 or 270 degrees.
+func rotateRightAngle(img *gocv.Mat, degrees int) affine {
+	w, h := float64(img.Cols()), float64(img.Rows())
+	dst := gocv.NewMat()
+
+	var forward affine
+	switch degrees {
+	case 90:
+		gocv.Rotate(*img, &dst, gocv.Rotate90Clockwise)
+		forward = affine{0, -1, h, 1, 0, 0}
+	case 180:
+		gocv.Rotate(*img, &dst, gocv.Rotate180Clockwise)
+		forward = affine{-1, 0, w, 0, -1, h}
+	case 270:
+		gocv.Rotate(*img, &dst, gocv.Rotate90CounterClockwise)
+		forward = affine{0, 1, 0, -1, 0, w}
+	default:
+		dst.Close()
+		return identityAffine()
+	}
+
+	replaceMat(img, dst)
+	return forward
+}
+
+// estimateSkew returns the median angle of long, nearly horizontal edges in
+// degrees, positive when text runs downhill to the right. Angles too small to
+// matter or too large to be a tilt are reported as no skew.
+func estimateSkew(img gocv.Mat) (float64, bool) {
+	gray := gocv.NewMat()
+	defer gray.Close()
+	gocv.CvtColor(img, &gray, gocv.ColorBGRToGray)
+
+	edges := gocv.NewMat()
+	defer edges.Close()
+	gocv.Canny(gray, &edges, 50, 150)
+
+	lines := gocv.NewMat()
+	defer lines.Close()
+	minLength := float32(max(img.Cols(), img.Rows())) / 8
+	gocv.HoughLinesPWithParams(edges, &lines, 1, math.Pi/180, 80, minLength, 10)
+
+	var angles []float64
+	for i := 0; i < lines.Rows(); i++ {
+		line := lines.GetVeciAt(i, 0)
+		angle := math.Atan2(float64(line[3]-line[1]), float64(line[2]-line[0])) * 180 / math.Pi
+		if angle > 90 {
+			angle -= 180
+		} else if angle < -90 {
+			angle += 180
+		}
+		if math.Abs(angle) < 45 {
+			angles = append(angles, angle)
+		}
+	}
+
+	if len(angles) < minSkewLines {
+		log.Printf("[OCR ORIENTATION] Skew estimate skipped, only %d horizontal lines found", len(angles))
+		return 0, false
+	}
+	sort.Float64s(angles)
+	skew := angles[len(angles)/2]
+	log.Printf("[OCR ORIENTATION] Estimated skew %.2f degrees from %d lines", skew, len(angles))
+
+	if math.Abs(skew) < minSkewDegrees || math.Abs(skew) > maxSkewDegrees {
+		return 0, false
+	}
+	return skew, true
+}
+
+// deskewImage rotates img counter-clockwise by degrees, growing the canvas so
+// no corner is cut off.
+func deskewImage(img *gocv.Mat, degrees float64) affine {
+	w, h := float64(img.Cols()), float64(img.Rows())
+	radians := degrees * math.Pi / 180
+	cos, sin := math.Abs(math.Cos(radians)), math.Abs(math.Sin(radians))
+	newW, newH := int(math.Ceil(w*cos+h*sin)), int(math.Ceil(w*sin+h*cos))
+
+	matrix := gocv.GetRotationMatrix2D(image.Pt(img.Cols()/2, img.Rows()/2), degrees, 1)
+	defer matrix.Close()
+	matrix.SetDoubleAt(0, 2, matrix.GetDoubleAt(0, 2)+float64(newW)/2-float64(img.Cols()/2))
+	matrix.SetDoubleAt(1, 2, matrix.GetDoubleAt(1, 2)+float64(newH)/2-float64(img.Rows()/2))
+
+	var forward affine
+	for i := range forward {
+		forward[i] = matrix.GetDoubleAt(i/3, i%3)
+	}
+
+	dst := gocv.NewMat()
+	gocv.WarpAffineWithParams(*img, &dst, matrix, image.Pt(newW, newH), gocv.InterpolationLinear, gocv.BorderConstant, orientationWhiteBackdrop)
+	replaceMat(img, dst)
+	return forward
+}
+
+// jpegEXIFOrientation reads the orientation tag from a JPEG's APP1 EXIF
+// segment. Images without one are reported as 1, already upright.
+func jpegEXIFOrientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		segmentLength := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + segmentLength
+		if segmentLength < 2 || end > len(data) {
+			break
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+			return exifOrientation(data[i+10 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if tiff[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			break
+		}
+	}
+	return 1
+}
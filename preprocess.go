@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// PreprocessStep is one stage of a preprocessing profile. Apply reads src and
+// writes its result to dst; src stays owned by the caller.
+type PreprocessStep interface {
+	Name() string
+	Apply(src gocv.Mat, dst *gocv.Mat)
+}
+
+// PreprocessProfile is a named chain of steps that prepares a text region
+// for recognition. Every profile starts from a grayscale copy of the region.
+type PreprocessProfile struct {
+	Name  string
+	Steps []PreprocessStep
+}
+
+// Run applies every step in order and returns a new Mat the caller must
+// close.
+func (p *PreprocessProfile) Run(src gocv.Mat) gocv.Mat {
+	current := gocv.NewMat()
+	if src.Channels() == 1 {
+		src.CopyTo(&current)
+	} else {
+		gocv.CvtColor(src, &current, gocv.ColorBGRToGray)
+	}
+
+	for _, step := range p.Steps {
+		next := gocv.NewMat()
+		step.Apply(current, &next)
+		current.Close()
+		current = next
+	}
+	return current
+}
+
+func (p *PreprocessProfile) String() string {
+	names := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		names[i] = step.Name()
+	}
+	return p.Name + "[" + strings.Join(names, " -> ") + "]"
+}
+
+// ScaleStep resizes by a fixed factor, or, when TargetHeight is set, by
+// whatever factor brings the region to that height. Tesseract reads best
+// when glyphs are a few dozen pixels tall, which is what TargetHeight is
+// for; MaxFactor keeps tiny noise regions from being blown up.
+type ScaleStep struct {
+	Factor       float64
+	TargetHeight int
+	MaxFactor    float64
+}
+
+func (s ScaleStep) Name() string {
+	if s.TargetHeight > 0 {
+		return fmt.Sprintf("scale:height=%d,max=%g", s.TargetHeight, s.MaxFactor)
+	}
+	return fmt.Sprintf("scale:factor=%g", s.Factor)
+}
+
+func (s ScaleStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	factor := s.Factor
+	if s.TargetHeight > 0 && src.Rows() > 0 {
+		factor = float64(s.TargetHeight) / float64(src.Rows())
+		factor = max(1, factor)
+		if s.MaxFactor > 0 {
+			factor = min(s.MaxFactor, factor)
+		}
+	}
+	if factor <= 0 || factor == 1 {
+		src.CopyTo(dst)
+		return
+	}
+
+	interpolation := gocv.InterpolationCubic
+	if factor < 1 {
+		interpolation = gocv.InterpolationArea
+	}
+	size := image.Pt(max(1, int(float64(src.Cols())*factor)), max(1, int(float64(src.Rows())*factor)))
+	gocv.Resize(src, dst, size, 0, 0, interpolation)
+}
+
+// CLAHEStep equalizes contrast locally, which brings out lettering on signs
+// that are lit unevenly.
+type CLAHEStep struct {
+	ClipLimit float64
+	TileSize  int
+}
+
+func (s CLAHEStep) Name() string {
+	return fmt.Sprintf("clahe:clip=%g,tile=%d", s.ClipLimit, s.TileSize)
+}
+
+func (s CLAHEStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	clahe := gocv.NewCLAHEWithParams(s.ClipLimit, image.Pt(s.TileSize, s.TileSize))
+	defer clahe.Close()
+	clahe.Apply(src, dst)
+}
+
+type DenoiseStep struct {
+	Strength float32
+}
+
+func (s DenoiseStep) Name() string {
+	return fmt.Sprintf("denoise:h=%g", s.Strength)
+}
+
+func (s DenoiseStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	gocv.FastNlMeansDenoisingWithParams(src, dst, s.Strength, 7, 21)
+}
+
+type AdaptiveThresholdStep struct {
+	BlockSize int
+	C         float32
+}
+
+func (s AdaptiveThresholdStep) Name() string {
+	return fmt.Sprintf("adaptive:block=%d,c=%g", s.BlockSize, s.C)
+}
+
+func (s AdaptiveThresholdStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	gocv.AdaptiveThreshold(src, dst, 255, gocv.AdaptiveThresholdGaussian, gocv.ThresholdBinary, s.BlockSize, s.C)
+}
+
+type OtsuThresholdStep struct{}
+
+func (s OtsuThresholdStep) Name() string {
+	return "otsu"
+}
+
+func (s OtsuThresholdStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	gocv.Threshold(src, dst, 0, 255, gocv.ThresholdBinary|gocv.ThresholdOtsu)
+}
+
+// InvertStep flips light-on-dark images to the dark-on-light polarity
+// tesseract expects. A mostly dark image is taken to be light text on a dark
+// background, as on neon signs and blackboard menus. It belongs before any
+// threshold step: an adaptive threshold leaves mostly white whatever the
+// polarity, so the mean no longer tells the two apart.
+type InvertStep struct{}
+
+func (s InvertStep) Name() string {
+	return "invert"
+}
+
+func (s InvertStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	if mean := src.Mean().Val1; mean < 128 {
+		log.Printf("[OCR PREPROCESSING] Mean intensity %.0f looks like light text on dark background, inverting", mean)
+		gocv.BitwiseNot(src, dst)
+		return
+	}
+	src.CopyTo(dst)
+}
+
+type MorphologyStep struct {
+	Op         gocv.MorphType
+	OpName     string
+	KernelSize int
+}
+
+func (s MorphologyStep) Name() string {
+	return fmt.Sprintf("morph:op=%s,kernel=%d", s.OpName, s.KernelSize)
+}
+
+func (s MorphologyStep) Apply(src gocv.Mat, dst *gocv.Mat) {
+	kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Pt(s.KernelSize, s.KernelSize))
+	defer kernel.Close()
+	gocv.MorphologyEx(src, dst, s.Op, kernel)
+}
+
+var morphologyOps = map[string]gocv.MorphType{
+	"open":   gocv.MorphOpen,
+	"close":  gocv.MorphClose,
+	"erode":  gocv.MorphErode,
+	"dilate": gocv.MorphDilate,
+}
+
+// parsePreprocessStep builds a step from a spec such as "clahe:clip=3,tile=8".
+// Parameters left out keep their defaults.
+func parsePreprocessStep(spec string) (PreprocessStep, error) {
+	name, rawParams, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	params := map[string]string{}
+	for _, pair := range strings.Split(rawParams, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("step %q: parameter %q must be key=value", spec, pair)
+		}
+		params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	var err error
+	number := func(key string, fallback float64) float64 {
+		value, ok := params[key]
+		if !ok || err != nil {
+			return fallback
+		}
+		parsed, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			err = fmt.Errorf("step %q: parameter %s must be a number", spec, key)
+		}
+		return parsed
+	}
+	// positive and positiveInt reject zero and negative values at parse
+	// time, since OpenCV would otherwise fail or misbehave on every request.
+	positive := func(key string, fallback float64) float64 {
+		value := number(key, fallback)
+		if err == nil && value <= 0 {
+			err = fmt.Errorf("step %q: parameter %s must be positive", spec, key)
+		}
+		return value
+	}
+	positiveInt := func(key string, fallback int) int {
+		value := int(number(key, float64(fallback)))
+		if err == nil && value < 1 {
+			err = fmt.Errorf("step %q: parameter %s must be a positive integer", spec, key)
+		}
+		return value
+	}
+
+	var step PreprocessStep
+	switch name {
+	case "scale":
+		// height is optional; 0 means scale by factor alone.
+		height := 0
+		if _, ok := params["height"]; ok {
+			height = positiveInt("height", 0)
+		}
+		step = ScaleStep{Factor: positive("factor", 2), TargetHeight: height, MaxFactor: positive("max", 4)}
+	case "clahe":
+		step = CLAHEStep{ClipLimit: positive("clip", 2), TileSize: positiveInt("tile", 8)}
+	case "denoise":
+		step = DenoiseStep{Strength: float32(positive("h", 10))}
+	case "adaptive":
+		blockSize := int(number("block", 11))
+		if blockSize < 3 || blockSize%2 == 0 {
+			return nil, fmt.Errorf("step %q: block must be an odd number of at least 3", spec)
+		}
+		step = AdaptiveThresholdStep{BlockSize: blockSize, C: float32(number("c", 2))}
+	case "otsu":
+		step = OtsuThresholdStep{}
+	case "invert":
+		step = InvertStep{}
+	case "morph":
+		opName := params["op"]
+		if opName == "" {
+			opName = "close"
+		}
+		op, ok := morphologyOps[opName]
+		if !ok {
+			return nil, fmt.Errorf("step %q: unknown morphology op %q", spec, opName)
+		}
+		step = MorphologyStep{Op: op, OpName: opName, KernelSize: max(1, int(number("kernel", 2)))}
+	default:
+		return nil, fmt.Errorf("unknown preprocessing step %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return step, nil
+}
+
+func newPreprocessProfile(name string, specs []string) (*PreprocessProfile, error) {
+	profile := &PreprocessProfile{Name: name}
+	for _, spec := range specs {
+		step, err := parsePreprocessStep(spec)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		profile.Steps = append(profile.Steps, step)
+	}
+	return profile, nil
+}
+
+const DefaultPreprocessProfile = "default"
+
+// builtinPreprocessProfiles are always available. "default" reproduces the
+// original grayscale, 2x cubic resize and adaptive threshold path.
+var builtinPreprocessProfiles = map[string][]string{
+	DefaultPreprocessProfile: {"scale:factor=2", "adaptive:block=11,c=2"},
+	"menu":                   {"scale:height=64,max=4", "denoise:h=7", "invert", "adaptive:block=31,c=10", "morph:op=close,kernel=2"},
+	"signboard":              {"clahe:clip=3,tile=8", "scale:height=64,max=4", "denoise:h=10", "invert", "otsu", "morph:op=close,kernel=2"},
+}
+
+var (
+	preprocessProfiles    = map[string]*PreprocessProfile{}
+	defaultProfileName    = DefaultPreprocessProfile
+	preprocessProfileList []string
+)
+
+// loadPreprocessConfig builds the built-in profiles and any extra ones from
+// the JSON file in OCR_PREPROCESS_PROFILES_FILE, which maps profile names to
+// step specs and may also override the built-ins.
+func loadPreprocessConfig() error {
+	specs := make(map[string][]string, len(builtinPreprocessProfiles))
+	for name, steps := range builtinPreprocessProfiles {
+		specs[name] = steps
+	}
+
+	if path := os.Getenv("OCR_PREPROCESS_PROFILES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read preprocessing profiles file: %w", err)
+		}
+		var custom map[string][]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return fmt.Errorf("failed to parse preprocessing profiles file: %w", err)
+		}
+		for name, steps := range custom {
+			specs[name] = steps
+		}
+	}
+
+	profiles := make(map[string]*PreprocessProfile, len(specs))
+	for name, steps := range specs {
+		profile, err := newPreprocessProfile(name, steps)
+		if err != nil {
+			return err
+		}
+		profiles[name] = profile
+	}
+
+	defaultName := os.Getenv("OCR_PREPROCESS_PROFILE")
+	if defaultName == "" {
+		defaultName = DefaultPreprocessProfile
+	}
+	if _, ok := profiles[defaultName]; !ok {
+		return fmt.Errorf("default preprocessing profile %q is not defined", defaultName)
+	}
+
+	preprocessProfiles = profiles
+	defaultProfileName = defaultName
+	preprocessProfileList = preprocessProfileList[:0]
+	for name := range profiles {
+		preprocessProfileList = append(preprocessProfileList, name)
+	}
+	sort.Strings(preprocessProfileList)
+
+	for _, name := range preprocessProfileList {
+		log.Printf("[PREPROCESS CONFIG] Profile %s", profiles[name])
+	}
+	log.Printf("[PREPROCESS CONFIG] Default profile: %s", defaultProfileName)
+	return nil
+}
+
+func lookupPreprocessProfile(name string) (*PreprocessProfile, error) {
+	if name == "" {
+		name = defaultProfileName
+	}
+	profile, ok := preprocessProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile must be one of %s", strings.Join(preprocessProfileList, ", "))
+	}
+	return profile, nil
+}
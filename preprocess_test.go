@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParsePreprocessStep(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "scale"},
+		{spec: "scale:height=48,max=4"},
+		{spec: "scale:factor=0", wantErr: true},
+		{spec: "scale:factor=-2", wantErr: true},
+		{spec: "scale:max=0", wantErr: true},
+		{spec: "scale:height=0", wantErr: true},
+		{spec: "scale:factor=two", wantErr: true},
+		{spec: "clahe:clip=3,tile=16"},
+		{spec: "clahe:clip=0", wantErr: true},
+		{spec: "clahe:tile=0", wantErr: true},
+		{spec: "clahe:tile=-8", wantErr: true},
+		{spec: "denoise:h=7"},
+		{spec: "denoise:h=0", wantErr: true},
+		{spec: "adaptive:block=15,c=4"},
+		{spec: "adaptive:block=4", wantErr: true},
+		{spec: "morph:op=open,kernel=3"},
+		{spec: "morph:op=blur", wantErr: true},
+		{spec: "sharpen", wantErr: true},
+		{spec: "scale:factor", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			step, err := parsePreprocessStep(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && step == nil {
+				t.Fatal("got no step")
+			}
+		})
+	}
+}
+
+func TestBuiltinPreprocessProfilesParse(t *testing.T) {
+	for name, specs := range builtinPreprocessProfiles {
+		if _, err := newPreprocessProfile(name, specs); err != nil {
+			t.Errorf("builtin profile %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"image"
+	"log"
+	"sort"
+)
+
+// RegionMergeConfig controls how detected regions are cleaned up before
+// recognition. Overlap and gap thresholds are ratios so they scale with the
+// size of the lettering.
+type RegionMergeConfig struct {
+	Enabled bool
+	// NMSOverlap drops a region when its IoU with a larger kept region, or
+	// the share of its own area covered by one, exceeds this value.
+	NMSOverlap float64
+	// LineOverlap is the minimum vertical overlap, relative to the shorter
+	// region, for two regions to be on the same line.
+	LineOverlap float64
+	// LineGap is the largest horizontal gap, relative to the taller region,
+	// that still joins two regions of a line.
+	LineGap float64
+	// LineHeightRatio is the smallest height ratio of two regions that may
+	// be joined, so captions are not merged into headings beside them.
+	LineHeightRatio float64
+}
+
+var regionMerge = RegionMergeConfig{
+	Enabled:         true,
+	NMSOverlap:      0.7,
+	LineOverlap:     0.6,
+	LineGap:         1.0,
+	LineHeightRatio: 0.5,
+}
+
+func loadRegionMergeConfig() {
+	regionMerge.Enabled = getEnvBool("OCR_REGION_MERGE", regionMerge.Enabled)
+	regionMerge.NMSOverlap = getEnvFloat("OCR_REGION_NMS_OVERLAP", regionMerge.NMSOverlap)
+	regionMerge.LineOverlap = getEnvFloat("OCR_REGION_LINE_OVERLAP", regionMerge.LineOverlap)
+	regionMerge.LineGap = getEnvFloat("OCR_REGION_LINE_GAP", regionMerge.LineGap)
+	regionMerge.LineHeightRatio = getEnvFloat("OCR_REGION_LINE_HEIGHT_RATIO", regionMerge.LineHeightRatio)
+	log.Printf("[REGION MERGE CONFIG] Enabled: %t, NMS overlap: %.2f, line overlap: %.2f, line gap: %.2f, line height ratio: %.2f",
+		regionMerge.Enabled, regionMerge.NMSOverlap, regionMerge.LineOverlap, regionMerge.LineGap, regionMerge.LineHeightRatio)
+}
+
+// mergeTextRegions suppresses duplicate regions and then joins upright
+// regions that sit on the same line with small gaps between them, so a word
+// split into several boxes is read as one. Rotated regions only take part in
+// suppression.
+func mergeTextRegions(regions []TextRegion, cfg RegionMergeConfig) []TextRegion {
+	if !cfg.Enabled || len(regions) < 2 {
+		return regions
+	}
+
+	kept := suppressOverlappingRegions(regions, cfg.NMSOverlap)
+
+	var upright, rotated []TextRegion
+	for _, region := range kept {
+		if region.Rotated() {
+			rotated = append(rotated, region)
+		} else {
+			upright = append(upright, region)
+		}
+	}
+
+	lines := groupRegionsIntoLines(upright, cfg)
+	merged := append(lines, rotated...)
+	log.Printf("[OCR REGION MERGE] %d detected regions, %d after suppression, %d after line grouping", len(regions), len(kept), len(merged))
+	return merged
+}
+
+// suppressOverlappingRegions keeps regions largest first and drops any that
+// mostly repeat a region already kept.
+func suppressOverlappingRegions(regions []TextRegion, threshold float64) []TextRegion {
+	ordered := append([]TextRegion(nil), regions...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rectArea(ordered[i].Box) > rectArea(ordered[j].Box)
+	})
+
+	var kept []TextRegion
+	for _, candidate := range ordered {
+		suppressed := false
+		for _, region := range kept {
+			intersection := rectArea(candidate.Box.Intersect(region.Box))
+			if intersection == 0 {
+				continue
+			}
+			union := rectArea(candidate.Box) + rectArea(region.Box) - intersection
+			iou := float64(intersection) / float64(union)
+			covered := float64(intersection) / float64(max(1, rectArea(candidate.Box)))
+			if iou > threshold || covered > threshold {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			kept = append(kept, candidate)
+		}
+	}
+	return kept
+}
+
+// groupRegionsIntoLines repeatedly joins pairs of regions that belong to the
+// same line until no pair is left, then returns the lines top to bottom.
+func groupRegionsIntoLines(regions []TextRegion, cfg RegionMergeConfig) []TextRegion {
+	lines := append([]TextRegion(nil), regions...)
+	for merged := true; merged; {
+		merged = false
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].Box.Min.X < lines[j].Box.Min.X })
+		for i := 0; i < len(lines) && !merged; i++ {
+			for j := i + 1; j < len(lines); j++ {
+				if sameTextLine(lines[i].Box, lines[j].Box, cfg) {
+					lines[i].Box = lines[i].Box.Union(lines[j].Box)
+					lines[i].Score = max(lines[i].Score, lines[j].Score)
+					lines = append(lines[:j], lines[j+1:]...)
+					merged = true
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].Box.Min.Y != lines[j].Box.Min.Y {
+			return lines[i].Box.Min.Y < lines[j].Box.Min.Y
+		}
+		return lines[i].Box.Min.X < lines[j].Box.Min.X
+	})
+	return lines
+}
+
+func sameTextLine(a, b image.Rectangle, cfg RegionMergeConfig) bool {
+	shorter, taller := min(a.Dy(), b.Dy()), max(a.Dy(), b.Dy())
+	if shorter <= 0 || float64(shorter)/float64(taller) < cfg.LineHeightRatio {
+		return false
+	}
+
+	overlap := min(a.Max.Y, b.Max.Y) - max(a.Min.Y, b.Min.Y)
+	if float64(overlap)/float64(shorter) < cfg.LineOverlap {
+		return false
+	}
+
+	gap := max(a.Min.X, b.Min.X) - min(a.Max.X, b.Max.X)
+	return float64(gap) <= cfg.LineGap*float64(taller)
+}
+
+func rectArea(r image.Rectangle) int {
+	if r.Empty() {
+		return 0
+	}
+	return r.Dx() * r.Dy()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ErrorCodePayloadTooLarge    = "payload_too_large"
+	ErrorCodeImageTooLarge      = "image_too_large"
+	ErrorCodeUnsupportedFormat  = "unsupported_media_type"
+	ErrorCodeCorruptImage       = "corrupt_image"
+	ErrorCodeUnsupportedDecoder = "unsupported_image_format"
+
+	ErrorCodeInvalidParameter = "invalid_parameter"
+	ErrorCodeOCRFailed        = "ocr_failed"
+	ErrorCodeFilterFailed     = "filter_failed"
+	ErrorCodeTimeout          = "request_timeout"
+	ErrorCodeQueueFull        = "queue_full"
+	ErrorCodeQueueTimeout     = "queue_timeout"
+)
+
+var (
+	// maxRequestBytes defaults to room for a base64 encoded image of
+	// maxImageBytes, so it is set once MAX_IMAGE_BYTES has been read.
+	maxRequestBytes      int64
+	maxBatchRequestBytes int64 = 200 << 20
+	maxImagePixels             = 40_000_000
+)
+
+// requestOverheadBytes is what a single image request may carry besides
+// the image: JSON fields, multipart headers and the like.
+const requestOverheadBytes = 1 << 20
+
+func loadUploadLimitsConfig() {
+	base64ImageBytes := (maxImageBytes + 2) / 3 * 4
+	maxRequestBytes = int64(getEnvInt("MAX_REQUEST_BYTES", int(base64ImageBytes+requestOverheadBytes)))
+	maxBatchRequestBytes = int64(getEnvInt("MAX_BATCH_REQUEST_BYTES", int(maxBatchRequestBytes)))
+	maxImagePixels = getEnvInt("MAX_IMAGE_PIXELS", maxImagePixels)
+	log.Printf("[UPLOAD LIMITS CONFIG] Max request: %d bytes, max batch request: %d bytes, max image pixels: %d", maxRequestBytes, maxBatchRequestBytes, maxImagePixels)
+}
+
+// bodyLimitMiddleware rejects requests whose declared size is over limit and
+// caps the body reader for requests that lie about or omit Content-Length.
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			log.Printf("[HTTP UPLOAD LIMIT] Rejecting %d byte request from client IP: %s, limit is %d bytes", c.Request.ContentLength, c.ClientIP(), limit)
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, OCRResponse{
+				Success:   false,
+				Message:   fmt.Sprintf("request body exceeds %d bytes", limit),
+				ErrorCode: ErrorCodePayloadTooLarge,
+			})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// bodyTooLargeError converts the error a capped body reader returns into a
+// 413 input error. It returns nil for any other error.
+func bodyTooLargeError(err error) *inputError {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return nil
+	}
+	return &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)}
+}
+
+// detectImageFormat identifies an image by its magic bytes. It returns ""
+// for anything that is not one of the formats we know how to size.
+func detectImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff"
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) >= 26:
+		return "bmp"
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return "heic"
+		}
+	}
+	return ""
+}
+
+// imageDimensions reads width and height from the image header without
+// decoding pixel data.
+func imageDimensions(format string, data []byte) (int, int, error) {
+	switch format {
+	case "png":
+		if len(data) < 24 {
+			return 0, 0, fmt.Errorf("truncated PNG header")
+		}
+		return int(binary.BigEndian.Uint32(data[16:20])), int(binary.BigEndian.Uint32(data[20:24])), nil
+	case "jpeg":
+		return jpegDimensions(data)
+	case "webp":
+		return webpDimensions(data)
+	case "tiff":
+		return tiffDimensions(data)
+	case "bmp":
+		width := int(int32(binary.LittleEndian.Uint32(data[18:22])))
+		height := int(int32(binary.LittleEndian.Uint32(data[22:26])))
+		return abs(width), abs(height), nil
+	case "heic":
+		if idx := bytes.Index(data, []byte("ispe")); idx >= 0 && len(data) >= idx+16 {
+			return int(binary.BigEndian.Uint32(data[idx+8 : idx+12])), int(binary.BigEndian.Uint32(data[idx+12 : idx+16])), nil
+		}
+		return 0, 0, fmt.Errorf("HEIC image size not found")
+	}
+	return 0, 0, fmt.Errorf("unknown image format")
+}
+
+func jpegDimensions(data []byte) (int, int, error) {
+	i := 2
+	for i+9 < len(data) {
+		if data[i] != 0xFF {
+			return 0, 0, fmt.Errorf("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+		segmentLength := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC {
+			height := int(binary.BigEndian.Uint16(data[i+5 : i+7]))
+			width := int(binary.BigEndian.Uint16(data[i+7 : i+9]))
+			return width, height, nil
+		}
+		i += 2 + segmentLength
+	}
+	return 0, 0, fmt.Errorf("JPEG frame header not found")
+}
+
+func webpDimensions(data []byte) (int, int, error) {
+	if len(data) < 30 {
+		return 0, 0, fmt.Errorf("truncated WebP header")
+	}
+	switch string(data[12:16]) {
+	case "VP8 ":
+		width := int(binary.LittleEndian.Uint16(data[26:28]) & 0x3FFF)
+		height := int(binary.LittleEndian.Uint16(data[28:30]) & 0x3FFF)
+		return width, height, nil
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return int(bits&0x3FFF) + 1, int((bits>>14)&0x3FFF) + 1, nil
+	case "VP8X":
+		width := int(uint32(data[24]) | uint32(data[25])<<8 | uint32(data[26])<<16)
+		height := int(uint32(data[27]) | uint32(data[28])<<8 | uint32(data[29])<<16)
+		return width + 1, height + 1, nil
+	}
+	return 0, 0, fmt.Errorf("unknown WebP chunk")
+}
+
+func tiffDimensions(data []byte) (int, int, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	if len(data) < 8 {
+		return 0, 0, fmt.Errorf("truncated TIFF header")
+	}
+
+	offset := int(order.Uint32(data[4:8]))
+	if offset+2 > len(data) {
+		return 0, 0, fmt.Errorf("TIFF IFD out of range")
+	}
+	entries := int(order.Uint16(data[offset : offset+2]))
+
+	width, height := 0, 0
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(data) {
+			break
+		}
+		tag := order.Uint16(data[entry : entry+2])
+		fieldType := order.Uint16(data[entry+2 : entry+4])
+		value := int(order.Uint32(data[entry+8 : entry+12]))
+		if fieldType == 3 {
+			value = int(order.Uint16(data[entry+8 : entry+10]))
+		}
+		switch tag {
+		case 256:
+			width = value
+		case 257:
+			height = value
+		}
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("TIFF image size not found")
+	}
+	return width, height, nil
+}
+
+// validateImageData checks format and pixel count from the header so that a
+// decompression bomb is rejected before OpenCV allocates memory for it.
+func validateImageData(data []byte) error {
+	if int64(len(data)) > maxImageBytes {
+		return &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodePayloadTooLarge, Message: fmt.Sprintf("image exceeds %d bytes", maxImageBytes)}
+	}
+
+	format := detectImageFormat(data)
+	if format == "" {
+		contentType := http.DetectContentType(data)
+		log.Printf("[IMAGE VALIDATION] Rejected input with unrecognized format, sniffed content type %s", contentType)
+		return &inputError{Status: http.StatusUnsupportedMediaType, Code: ErrorCodeUnsupportedFormat, Message: fmt.Sprintf("unsupported content type %s, expected PNG, JPEG, WebP, TIFF or BMP", contentType)}
+	}
+	if format == "heic" {
+		log.Printf("[IMAGE VALIDATION] Rejected HEIC input, decoder does not support it")
+		return &inputError{Status: http.StatusUnsupportedMediaType, Code: ErrorCodeUnsupportedDecoder, Message: "HEIC images are not supported, convert to JPEG or PNG"}
+	}
+
+	width, height, err := imageDimensions(format, data)
+	if err != nil || width <= 0 || height <= 0 {
+		log.Printf("[IMAGE VALIDATION] Failed to read %s dimensions: %v", format, err)
+		return &inputError{Status: http.StatusUnsupportedMediaType, Code: ErrorCodeCorruptImage, Message: fmt.Sprintf("could not read %s image header", format)}
+	}
+
+	// Compared by division, since width*height can overflow for the sizes
+	// a forged header declares.
+	if width > maxImagePixels/height {
+		log.Printf("[IMAGE VALIDATION] Rejected %s image of %dx%d pixels, limit is %d pixels", format, width, height, maxImagePixels)
+		return &inputError{Status: http.StatusRequestEntityTooLarge, Code: ErrorCodeImageTooLarge, Message: fmt.Sprintf("image is %dx%d pixels, limit is %d pixels", width, height, maxImagePixels)}
+	}
+
+	log.Printf("[IMAGE VALIDATION] Accepted %s image of %dx%d pixels, %d bytes", format, width, height, len(data))
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func pngHeader(width, height uint32) []byte {
+	data := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	data = binary.BigEndian.AppendUint32(data, width)
+	data = binary.BigEndian.AppendUint32(data, height)
+	return append(data, 8, 0, 0, 0, 0)
+}
+
+func jpegHeader(width, height uint16) []byte {
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00}
+	data = append(data, 0xFF, 0xC0, 0x00, 0x11, 0x08)
+	data = binary.BigEndian.AppendUint16(data, height)
+	data = binary.BigEndian.AppendUint16(data, width)
+	return append(data, 0x03, 0x01, 0x22, 0x00)
+}
+
+func TestValidateImageData(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		status int
+		code   string
+	}{
+		{name: "png", data: pngHeader(1920, 1080)},
+		{name: "jpeg", data: jpegHeader(4000, 3000)},
+		{name: "png at the pixel limit", data: pngHeader(8000, 5000)},
+		{name: "png over the pixel limit", data: pngHeader(8000, 5001), status: http.StatusRequestEntityTooLarge, code: ErrorCodeImageTooLarge},
+		{name: "forged png size", data: pngHeader(0xFFFFFFFF, 0xFFFFFFFF), status: http.StatusRequestEntityTooLarge, code: ErrorCodeImageTooLarge},
+		{name: "zero height", data: pngHeader(100, 0), status: http.StatusUnsupportedMediaType, code: ErrorCodeCorruptImage},
+		{name: "truncated png", data: pngHeader(100, 100)[:20], status: http.StatusUnsupportedMediaType, code: ErrorCodeCorruptImage},
+		{name: "text", data: []byte("hello, world"), status: http.StatusUnsupportedMediaType, code: ErrorCodeUnsupportedFormat},
+		{name: "heic", data: []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), status: http.StatusUnsupportedMediaType, code: ErrorCodeUnsupportedDecoder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImageData(tt.data)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var inputErr *inputError
+			if !errors.As(err, &inputErr) {
+				t.Fatalf("err = %v, want an input error", err)
+			}
+			if inputErr.Status != tt.status || inputErr.Code != tt.code {
+				t.Errorf("got %d %s, want %d %s", inputErr.Status, inputErr.Code, tt.status, tt.code)
+			}
+		})
+	}
+}